@@ -0,0 +1,121 @@
+package codegen
+
+// Preallocation
+//
+// Most methods that return a slice build it by appending to an empty slice.
+// When the length of the result can be determined in advance, it is cheaper
+// to allocate it once:
+//
+//    morphed := make([]int, 0, len(recv))
+//
+// Templates request this with the #alloc directive, which occupies a line of
+// its own:
+//
+//    #alloc(filtered, []#T, upper, len(xs))
+//
+// The third argument states how much is known about the final length of the
+// slice: "exact" if the fourth argument is its length, "upper" if it is an
+// upper bound, and "grow" if nothing is known (in which case the fourth
+// argument is omitted). Whether the directive becomes a make or a plain var
+// declaration is decided by the AllocPolicy of the compilation.
+//
+// Pipelines estimate the length of their result from the length of each
+// transformation: a morph produces exactly as many values as it receives, a
+// filter at most as many, and a flatten an unknown number, unless the caller
+// supplies a hint. The outline of each transformation that returns a slice
+// declares it with "#alloc(name, type, #cap)", and #cap is replaced with the
+// estimate.
+
+import (
+	"errors"
+	"regexp"
+)
+
+// An AllocPolicy determines when generated code preallocates the slices it
+// returns.
+type AllocPolicy int
+
+const (
+	// AllocExact preallocates a slice only when its final length is known,
+	// e.g. the result of a morph, or when the caller supplies a hint.
+	AllocExact AllocPolicy = iota
+	// AllocUpper additionally preallocates a slice when an upper bound on
+	// its length is known, e.g. the result of a filter. This may allocate
+	// more memory than is needed.
+	AllocUpper
+	// AllocGrow never preallocates; slices grow with append.
+	AllocGrow
+)
+
+var allocPolicyNames = [...]string{
+	AllocExact: "exact",
+	AllocUpper: "upper",
+	AllocGrow:  "grow",
+}
+
+// String implements flag.Value.
+func (p AllocPolicy) String() string {
+	if p < 0 || int(p) >= len(allocPolicyNames) {
+		return "invalid"
+	}
+	return allocPolicyNames[p]
+}
+
+// Set implements flag.Value.
+func (p *AllocPolicy) Set(s string) error {
+	for i, name := range allocPolicyNames {
+		if s == name {
+			*p = AllocPolicy(i)
+			return nil
+		}
+	}
+	return errors.New("alloc policy must be exact, upper, or grow")
+}
+
+var allocRegexp = regexp.MustCompile(`(?m)^([ \t]*)#alloc\((\w+), (.+), (exact|upper|grow)(?:, (.+))?\)$`)
+
+// expandAlloc replaces each #alloc directive in code with a declaration of
+// the slice, preallocating it if permitted by policy.
+func expandAlloc(code string, policy AllocPolicy) string {
+	return allocRegexp.ReplaceAllStringFunc(code, func(dir string) string {
+		m := allocRegexp.FindStringSubmatch(dir)
+		indent, name, typ, kind, n := m[1], m[2], m[3], m[4], m[5]
+		if (kind == "exact" && policy != AllocGrow) || (kind == "upper" && policy == AllocUpper) {
+			return indent + name + " := make(" + typ + ", 0, " + n + ")"
+		}
+		return indent + "var " + name + " " + typ
+	})
+}
+
+// A lengthKind relates the number of values produced by a transformation to
+// the number it receives.
+type lengthKind int
+
+const (
+	lengthUnknown lengthKind = iota // e.g. flatten
+	lengthSame                      // e.g. morph
+	lengthFewer                     // e.g. filter
+)
+
+// capacity returns the arguments of the #alloc directive for the slice
+// returned by p, if any.
+func (p *pipeline) capacity() string {
+	n, exact := "len(recv)", true
+	for _, t := range p.ts {
+		if t.hint != "" {
+			n, exact = t.hint, true
+			continue
+		}
+		switch t.length {
+		case lengthSame:
+		case lengthFewer:
+			exact = false
+		default:
+			return "grow"
+		}
+	}
+	if !exact {
+		return "upper, " + n
+	}
+	return "exact, " + n
+}
@@ -0,0 +1,77 @@
+package codegen
+
+import (
+	"go/ast"
+	"testing"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// TestAlloc checks that the slices returned by generated code are
+// preallocated according to the AllocPolicy and the estimated length of the
+// result. How this appears at runtime under the default policy is tested in
+// gen_test.ply.
+func TestAlloc(t *testing.T) {
+	tests := []struct {
+		expr   string
+		policy AllocPolicy
+		cap    string // capacity of the returned slice; empty if not preallocated
+	}{
+		{`xs.morph(double).reverse()`, AllocExact, `len(recv)`},
+		{`xs.morph(double).reverse()`, AllocUpper, `len(recv)`},
+		{`xs.morph(double).reverse()`, AllocGrow, ``},
+		{`xs.filter(even).morph(double)`, AllocExact, ``},
+		{`xs.filter(even).morph(double)`, AllocUpper, `len(recv)`},
+		{`m.filter(func(k, v int) bool { return k > v }).elems()`, AllocUpper, `len(recv)`},
+		{`xss.flatten().filter(even)`, AllocUpper, ``},
+		{`xss.flatten(10).morph(double)`, AllocExact, `__plyarg_0`},
+		{`xss.flatten(10).morph(double)`, AllocGrow, ``},
+		{`xs.flatMorph(pair, 2*len(xs)).take(3)`, AllocUpper, `__plyarg_1`},
+		{`xs.flatMorph(pair, 2*len(xs))`, AllocExact, `hint`},
+		{`xs.flatMorph(pair)`, AllocUpper, ``},
+		{`xs.filter(even)`, AllocExact, ``},
+		{`xs.filter(even)`, AllocUpper, `len(xs)`},
+		{`xs.union(xs)`, AllocUpper, `len(xs) + len(ys)`},
+	}
+
+	decls := `
+func even(x int) bool { return x%2 == 0 }
+
+func double(x int) int { return x * 2 }
+
+func pair(x int) []int { return []int{x, x} }
+`
+	for _, test := range tests {
+		code, err := compileMain(&Config{Alloc: test.policy}, decls, `
+	xs := []int{1, 2, 3}
+	xss := [][]int{xs, xs}
+	m := map[int]int{1: 2}
+	_, _, _ = xs, xss, m
+	_ = `+test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		_, generated, err := parseMain(code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// collect the capacity of each slice made by the generated code
+		var caps []string
+		for _, fn := range generated {
+			ast.Inspect(fn, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok && len(call.Args) == 3 {
+					if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "make" {
+						caps = append(caps, types.ExprString(call.Args[2]))
+					}
+				}
+				return true
+			})
+		}
+		if test.cap == "" && len(caps) != 0 {
+			t.Errorf("%s (%v): expected no preallocation, got capacity %v", test.expr, test.policy, caps)
+		} else if test.cap != "" && (len(caps) != 1 || caps[0] != test.cap) {
+			t.Errorf("%s (%v): expected capacity %v, got %v", test.expr, test.policy, test.cap, caps)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCallbackErrors checks that passing a callback with the wrong signature
+// to a ply function or method produces an error that describes the mismatch.
+func TestCallbackErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+	}{
+		{`xs.morph(func(s string) int { return 0 })`, `as func(int) int value in argument to morph: its parameter has type string, want int`},
+		{`xs.morph(func(i, x int) int { return 0 })`, `as func(int) U value in argument to morph: it has 2 parameters, want 1; did you mean morphi?`},
+		{`xs.morph(func(x int) (int, int) { return x, x })`, `it returns 2 values, want 1`},
+		{`xs.morph(3)`, `untyped int is not a function`},
+		{`xs.fold(func(acc string, x int) string { return acc })`, `as func(int, int) int value in argument to fold: its result has type string, want int; did you mean to pass an initial string value, as in fold(fn, init)?`},
+		{`xs.fold(func(acc string, x int) int { return 0 }, 0)`, `as func(int, int) int value in argument to fold: its first parameter has type string, want int`},
+		{`xs.fold(func(acc, i, x int) int { return 0 })`, `did you mean foldi?`},
+		{`xs.fold(func(acc, x int) int { return acc }, "")`, `cannot convert "" (untyped string constant) to int`},
+		{`xs.foldi(func(acc string, i int, x string) string { return acc }, "")`, `as func(string, int, int) string value in argument to foldi: its third parameter has type string, want int`},
+		{`m.morph(func(v string) bool { return true })`, `as func(int, string) (V, W) value in argument to morph: it has 1 parameter, want 2; did you mean mapValues?`},
+		{`m.mapKeys(func(k int, v string) int { return k })`, `did you mean morph?`},
+		{`xs.sort(func(x int) int { return x })`, `did you mean sortBy?`},
+		{`xs.sortBy(func(a, b int) bool { return a < b })`, `did you mean sort?`},
+		{`xs.flatMorph(func(x int) int { return x })`, `its result has type int, want a slice; did you mean morph?`},
+		{`zip(func(a int) int { return a }, xs, xs)`, `as func(int, int) V value in argument to zip: it has 1 parameter, want 2`},
+		{`not(func(a int) int { return a })`, `its result has type int, want bool`},
+		{`compose(func(s string) int { return 0 }, func(x int) int { return x })`, `as func(int) int value in argument to compose: its parameter has type string, want int`},
+		{`first(xs.partition(func(s string) bool { return true }))`, `as func(int) bool value in argument to partition: its parameter has type string, want int`},
+		{`first(xs.find(func(i, x int) bool { return true }))`, `as func(int) bool value in argument to find: it has 2 parameters, want 1`},
+
+		// ordinary ply methods
+		{`m.contains("a")`, `cannot convert "a" (untyped string constant) to int`},
+		{`m.contains(x)`, `cannot use x (variable of type string) as int value in argument to contains`},
+		{`xs.filter(func(x int) int { return x })`, `as func(int) bool value in argument to xs.filter: its result has type int, want bool`},
+		{`xs.filter(func(i, x int) bool { return true })`, `did you mean filteri?`},
+		{`xs.anyi(func(x int) bool { return true })`, `did you mean any?`},
+		{`m.filter(func(k int) bool { return true })`, `as func(int, string) bool value in argument to m.filter: it has 1 parameter, want 2`},
+	}
+
+	for _, test := range tests {
+		_, err := compileMain(nil, "func first(a, b interface{}) interface{} { return a }\n", `
+	xs := []int{1, 2, 3}
+	m := map[int]string{1: "a"}
+	x := "a"
+	_, _, _ = xs, m, x
+	_ = `+test.expr)
+		if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if !strings.Contains(err.Error(), test.exp) {
+			t.Errorf("%s: expected error containing %q, got %q", test.expr, test.exp, err)
+		}
+	}
+}
+
+// TestShadowing checks that declared functions and methods take precedence
+// over ply builtins, even where the declared method cannot be called.
+func TestShadowing(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string // expected error, if any
+	}{
+		{`merge(1, 2)`, ``},
+		{`xs.take(1)`, ``},
+		{`xs.filter(even).take(1)`, `take is not in method set of ints`},
+		{`ints{1}.take(1)`, `take is not in method set of ints`},
+	}
+
+	decls := `
+type ints []int
+
+func (p *ints) take(n int) ints { return (*p)[:n] }
+
+func merge(a, b int) int { return a + b }
+
+func even(x int) bool { return x%2 == 0 }
+`
+	for _, test := range tests {
+		_, err := compileMain(nil, decls, `
+	xs := ints{1, 2, 3}
+	_ = xs
+	_ = `+test.expr)
+		if test.exp == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.expr, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected error", test.expr)
+		} else if !strings.Contains(err.Error(), test.exp) {
+			t.Errorf("%s: expected error containing %q, got %q", test.expr, test.exp, err)
+		}
+	}
+}
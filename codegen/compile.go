@@ -3,14 +3,16 @@ package codegen
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/printer"
+	"go/scanner"
 	"go/token"
-	"log"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/lukechampine/ply/importer"
 	"github.com/lukechampine/ply/types"
@@ -24,21 +26,38 @@ import (
 // specialized function.
 type specializer struct {
 	types       map[ast.Expr]types.TypeAndValue
+	uses        map[*ast.Ident]types.Object
+	selections  map[*ast.SelectorExpr]*types.Selection
+	mutated     map[types.Object]bool // variables modified after declaration
+	names       *namer
 	fset        *token.FileSet
 	pkg         *ast.Package
 	fileImports map[string]string   // e.g. "math/big" -> "big"
 	implImports map[string]struct{} // new imports required by impls
+	hoisted     map[string]string   // e.g. "not(even)" -> "__plyfn_1_not_even"
+	alloc       AllocPolicy         // when to preallocate returned slices
+	purity      *purityAnalysis     // side effects of callbacks
+	warnf       func(error)         // called with each Warning; may be nil
+	errs        *ErrorList          // codegen failures
 }
 
-func hasMethod(recv ast.Expr, method string, exprTypes map[ast.Expr]types.TypeAndValue) bool {
-	// TODO: use set.Lookup instead of searching manually
-	set := types.NewMethodSet(exprTypes[recv].Type)
-	for i := 0; i < set.Len(); i++ {
-		if set.At(i).Obj().(*types.Func).Name() == method {
-			return true
-		}
-	}
-	return false
+// isPlyMethod reports whether the type checker resolved fn to a ply method.
+// Fields and methods declared by the program always take precedence over ply
+// methods of the same name, including methods with pointer receivers and
+// those promoted from embedded fields. Ply methods themselves have neither a
+// package nor a receiver (unlike the Error method of error, which has no
+// package either).
+func isPlyMethod(fn *ast.SelectorExpr, selections map[*ast.SelectorExpr]*types.Selection) bool {
+	sel, ok := selections[fn]
+	return ok && sel.Kind() == types.MethodVal && sel.Obj().Pkg() == nil &&
+		sel.Obj().Type().(*types.Signature).Recv() == nil
+}
+
+// isPlyFunc reports whether the type checker resolved fn to a ply function,
+// rather than a declaration of the same name.
+func isPlyFunc(fn *ast.Ident, uses map[*ast.Ident]types.Object) bool {
+	_, ok := uses[fn].(*types.Ply)
+	return ok
 }
 
 func findImports(fileImports []*ast.ImportSpec, pkgImports map[string]string) map[string]string {
@@ -56,14 +75,15 @@ func findImports(fileImports []*ast.ImportSpec, pkgImports map[string]string) ma
 	return imports
 }
 
-func (s specializer) addDecl(filename, code string) {
+func (s specializer) addDecl(filename, code string) error {
 	if _, ok := s.pkg.Files[filename]; ok {
 		// check for existence first, because parsing is expensive
-		return
+		return nil
 	}
 
-	// add package header to code
-	code = "package " + s.pkg.Name + code
+	// add package header to code, and declare the slices requested by
+	// #alloc directives
+	code = "package " + s.pkg.Name + expandAlloc(code, s.alloc)
 
 	// search for import qualifiers and replace them with the proper
 	// identifier
@@ -86,32 +106,71 @@ func (s specializer) addDecl(filename, code string) {
 
 	f, err := parser.ParseFile(s.fset, "", code, 0)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	s.pkg.Files[filename] = f
+	return nil
+}
+
+// genError records a failure to generate the code for the call at n. The
+// call is left unmodified.
+func (s specializer) genError(n ast.Node, name string, err error) {
+	*s.errs = append(*s.errs, GenError{
+		Fset: s.fset,
+		Pos:  n.Pos(),
+		Msg:  "could not generate " + name + ": " + err.Error(),
+	})
+}
+
+// warn reports a Warning about the call at n, if warnings are enabled.
+func (s specializer) warn(n ast.Node, format string, args ...interface{}) {
+	if s.warnf != nil {
+		s.warnf(Warning{
+			Fset: s.fset,
+			Pos:  n.Pos(),
+			Msg:  fmt.Sprintf(format, args...),
+		})
+	}
 }
 
 func (s specializer) Rewrite(node ast.Node) (ast.Node, gorewrite.Rewriter) {
 	switch n := node.(type) {
 	case *ast.CallExpr:
+		if e := s.eval(n); e != nil {
+			// the call was evaluated at compile time
+			return e, s
+		}
+		if e := s.hoist(n); e != nil {
+			// the call was replaced with a top-level function
+			return e, s
+		}
 		var rewrote bool
 		switch fn := n.Fun.(type) {
 		case *ast.Ident:
-			if gen, ok := funcGenerators[fn.Name]; ok {
+			if gen, ok := funcGenerators[fn.Name]; ok && isPlyFunc(fn, s.uses) {
 				if v := s.types[n].Value; v != nil {
 					// some functions (namely max/min) may evaluate to a
 					// constant, in which case we should replace the call with
 					// a constant expression.
 					node = ast.NewIdent(v.ExactString())
 				} else {
-					name, code, rewrite := gen(fn, n.Args, s.types)
-					s.addDecl(name, code)
+					name, code, rewrite := gen(s.names, fn, n.Args, s.types)
+					if err := s.addDecl(name, code); err != nil {
+						s.genError(n, name, err)
+						break
+					}
 					node = rewrite(n)
 					rewrote = true
 				}
 			}
 
 		case *ast.SelectorExpr:
+			if _, ok := s.types[fn.X]; !ok {
+				// the receiver was generated by a previous rewrite (e.g. a
+				// cast to a named type), so the call has already been
+				// specialized
+				break
+			}
 			// Detect and construct a pipeline if possible. Otherwise,
 			// generate a single method.
 			var chain []*ast.CallExpr
@@ -122,18 +181,35 @@ func (s specializer) Rewrite(node ast.Node) (ast.Node, gorewrite.Rewriter) {
 				}
 				chain = append(chain, cur)
 			}
-			if p := buildPipeline(chain, s.types); p != nil {
-				name, code, rewrite := p.gen()
-				s.addDecl(name, code)
+			p := buildPipeline(chain, s.types, s.selections)
+			for p != nil {
+				// stages whose callbacks may have side effects cannot
+				// always be pipelined; split them off, leaving them to be
+				// rewritten along with the receiver
+				k := s.purity.unsafeStages(p)
+				if k == 0 {
+					break
+				}
+				sel := p.fns[k-1].Fun.(*ast.SelectorExpr).Sel
+				s.warn(sel, "%s not pipelined with subsequent calls: a callback may have side effects", sel.Name)
+				p = buildPipeline(chain[:len(p.fns)-k], s.types, s.selections)
+			}
+			if p != nil {
+				s.findCallbacks(p)
+				name, code, rewrite := p.gen(s.names)
+				if err := s.addDecl(name, code); err != nil {
+					s.genError(n, name, err)
+					break
+				}
 				node = rewrite(n)
 				rewrote = true
-			} else if gen, ok := methodGenerators[fn.Sel.Name]; ok && !hasMethod(fn.X, fn.Sel.Name, s.types) {
-				name, code, rewrite := gen(fn, n.Args, s.types)
-				s.addDecl(name, code)
-				node = rewrite(n)
-				if fn.Sel.Name == "sort" {
-					s.implImports["sort"] = struct{}{}
+			} else if gen, ok := methodGenerators[fn.Sel.Name]; ok && isPlyMethod(fn, s.selections) {
+				name, code, rewrite := gen(s.names, fn, n.Args, s.types)
+				if err := s.addDecl(name, code); err != nil {
+					s.genError(n, name, err)
+					break
 				}
+				node = rewrite(n)
 				rewrote = true
 			}
 		}
@@ -164,23 +240,142 @@ func astToBytes(fset *token.FileSet, node interface{}) []byte {
 	return buf.Bytes()
 }
 
+// An installation is the result of installing an imported package.
+type installation struct {
+	once sync.Once
+	err  error
+}
+
+// installs records each installation, so that an import shared by multiple
+// packages is only installed once, even if the packages are compiled
+// concurrently.
+var installs = struct {
+	sync.Mutex
+	m map[string]*installation
+}{m: make(map[string]*installation)}
+
+// install installs the package with the specified import path. Subsequent
+// calls with the same path return the result of the first call.
+func install(path string) error {
+	installs.Lock()
+	inst, ok := installs.m[path]
+	if !ok {
+		inst = new(installation)
+		installs.m[path] = inst
+	}
+	installs.Unlock()
+
+	inst.once.Do(func() {
+		out, err := exec.Command("go", "install", path).CombinedOutput()
+		if err != nil {
+			inst.err = errors.New(string(out))
+		}
+	})
+	return inst.err
+}
+
+// A GenError is a failure to generate the code for a call to a ply function
+// or method. It indicates a bug in the Ply compiler rather than in the
+// program being compiled.
+type GenError struct {
+	Fset *token.FileSet // file set for interpretation of Pos
+	Pos  token.Pos      // position of the call
+	Msg  string         // error message
+}
+
+// Error returns an error string formatted as follows:
+// filename:line:column: message
+func (err GenError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Fset.Position(err.Pos), err.Msg)
+}
+
+// A Warning describes Ply code that compiles, but not as efficiently as it
+// might appear to; for example, a chain of calls that is not pipelined because
+// a callback may have side effects.
+type Warning struct {
+	Fset *token.FileSet // file set for interpretation of Pos
+	Pos  token.Pos      // position of the call
+	Msg  string         // warning message
+}
+
+// Error returns an error string formatted as follows:
+// filename:line:column: warning: message
+func (w Warning) Error() string {
+	return fmt.Sprintf("%s: warning: %s", w.Fset.Position(w.Pos), w.Msg)
+}
+
+// An ErrorList is a list of errors encountered while compiling a package. Its
+// elements are typically scanner.Errors, types.Errors, or GenErrors.
+type ErrorList []error
+
+// Error implements the error interface.
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// A Config specifies how packages are compiled. The zero Config is ready to
+// use.
+type Config struct {
+	// If AllErrors is set, compilation continues past the first error, and
+	// all errors are returned in an ErrorList. Otherwise, compilation stops
+	// at the first error, which is returned as-is.
+	AllErrors bool
+
+	// Alloc determines when generated code preallocates the slices it
+	// returns. The default is AllocExact.
+	Alloc AllocPolicy
+
+	// If Warn is non-nil, it is called with a Warning for each chain of calls
+	// that is not fully pipelined because a callback may have side effects.
+	// It may be called concurrently by concurrent calls to Compile.
+	Warn func(err error)
+}
+
+// Compile compiles the provided files as a single package using the default
+// Config. For each supplied .ply file, the compiled Go code is returned,
+// keyed by the original filename.
+func Compile(filenames []string) (map[string][]byte, error) {
+	return new(Config).Compile(filenames)
+}
+
 // Compile compiles the provided files as a single package. For each supplied
 // .ply file, the compiled Go code is returned, keyed by the original filename.
-func Compile(filenames []string) (map[string][]byte, error) {
+func (c *Config) Compile(filenames []string) (map[string][]byte, error) {
+	var errs ErrorList
 	// parse each supplied file
+	var mode parser.Mode = parser.ParseComments
+	if c.AllErrors {
+		mode |= parser.AllErrors
+	}
 	fset := token.NewFileSet()
 	var files []*ast.File
 	plyFiles := make(map[string]*ast.File)
 	for _, arg := range filenames {
-		f, err := parser.ParseFile(fset, arg, nil, parser.ParseComments)
+		f, err := parser.ParseFile(fset, arg, nil, mode)
 		if err != nil {
-			return nil, err
+			list, ok := err.(scanner.ErrorList)
+			if !c.AllErrors || !ok {
+				return nil, err
+			}
+			for _, e := range list {
+				errs = append(errs, *e)
+			}
+			continue
 		}
 		files = append(files, f)
 		if filepath.Ext(arg) == ".ply" {
 			plyFiles[arg] = f
 		}
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	if len(plyFiles) == 0 {
 		return nil, nil
 	}
@@ -188,21 +383,30 @@ func Compile(filenames []string) (map[string][]byte, error) {
 	// install each import
 	for _, f := range files {
 		for _, im := range f.Imports {
-			out, err := exec.Command("go", "install", strings.Trim(im.Path.Value, `"`)).CombinedOutput()
-			if err != nil {
-				return nil, errors.New(string(out))
+			if err := install(strings.Trim(im.Path.Value, `"`)); err != nil {
+				return nil, err
 			}
 		}
 	}
 
 	// type-check the package
 	info := types.Info{
-		Types: make(map[ast.Expr]types.TypeAndValue),
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
 	}
 	var conf types.Config
 	conf.Importer = importer.Default()
+	if c.AllErrors {
+		conf.Error = func(err error) {
+			errs = append(errs, err)
+		}
+	}
 	pkg, err := conf.Check("", fset, files, &info)
-	if err != nil {
+	if len(errs) > 0 {
+		return nil, errs
+	} else if err != nil {
 		return nil, err
 	}
 	// create import map
@@ -212,23 +416,44 @@ func Compile(filenames []string) (map[string][]byte, error) {
 	}
 
 	// walk the AST of each .ply file in the package, generating ply functions
-	// and rewriting their callsites
+	// and rewriting their callsites. All files share a namer, since their
+	// impls are declared in the same package.
 	set := make(map[string][]byte)
+	names := new(namer)
+	purity := newPurityAnalysis(pkg, files, &info)
 	for name, f := range plyFiles {
+		// select the embedded receivers of promoted ply methods
+		selectEmbedded(f, &info)
+
+		// combine statements that can be pipelined together
+		fuse(f, &info)
+
 		// create a specializer
 		spec := specializer{
-			types: info.Types,
-			fset:  fset,
+			types:      info.Types,
+			uses:       info.Uses,
+			selections: info.Selections,
+			mutated:    mutatedVars(f, &info),
+			names:      names,
+			fset:       fset,
 			pkg: &ast.Package{
 				Name:  pkg.Name(),
 				Files: make(map[string]*ast.File),
 			},
 			fileImports: findImports(f.Imports, pkgImports),
 			implImports: make(map[string]struct{}),
+			hoisted:     make(map[string]string),
+			alloc:       c.Alloc,
+			purity:      purity,
+			warnf:       c.Warn,
+			errs:        &errs,
 		}
 
 		// rewrite callsites while generating impls
 		gorewrite.Rewrite(spec, f)
+		if len(errs) > 0 && !c.AllErrors {
+			return nil, errs[0]
+		}
 
 		// add impl imports
 		for importPath := range spec.implImports {
@@ -240,6 +465,9 @@ func Compile(filenames []string) (map[string][]byte, error) {
 		impls = impls[bytes.IndexByte(impls, '\n'):] // remove package decl
 		set[name] = append(code, impls...)
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	return set, nil
 }
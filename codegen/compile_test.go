@@ -0,0 +1,93 @@
+package codegen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukechampine/ply/importer"
+	"github.com/lukechampine/ply/types"
+)
+
+// compileMain compiles a main package consisting of decls and a main
+// function whose body is body, returning the code generated for it. A nil
+// conf uses the default configuration.
+func compileMain(conf *Config, decls, body string) (string, error) {
+	if conf == nil {
+		conf = new(Config)
+	}
+	dir, err := ioutil.TempDir("", "ply")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "main.ply")
+	src := "package main\n\n" + decls + "\nfunc main() {\n" + body + "\n}\n"
+	if err := ioutil.WriteFile(filename, []byte(src), 0666); err != nil {
+		return "", err
+	}
+	set, err := conf.Compile([]string{filename})
+	return string(set[filename]), err
+}
+
+// parseMain parses code generated by compileMain, returning the body of main
+// and the functions generated for it.
+func parseMain(code string) (main *ast.BlockStmt, generated []*ast.FuncDecl, err error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", code, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range f.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok {
+			name := fn.Name.Name
+			if fn.Recv != nil {
+				name = types.ExprString(fn.Recv.List[0].Type)
+			}
+			if fn.Name.Name == "main" {
+				main = fn.Body
+			} else if strings.HasPrefix(name, "__ply") {
+				generated = append(generated, fn)
+			}
+		}
+	}
+	return main, generated, nil
+}
+
+// pipelineArgs returns the arguments passed to the first pipeline called in
+// main, or false if main does not call a pipeline.
+func pipelineArgs(code string) (string, bool) {
+	main, _, err := parseMain(code)
+	if err != nil {
+		return "", false
+	}
+	var args []string
+	found := false
+	ast.Inspect(main, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && !found {
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "pipeline" {
+				for _, arg := range call.Args {
+					args = append(args, types.ExprString(arg))
+				}
+				found = true
+			}
+		}
+		return !found
+	})
+	return strings.Join(args, ", "), found
+}
+
+// checkGenerated type-checks code generated by compileMain.
+func checkGenerated(code string) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "main.go", code, 0)
+	if err != nil {
+		return err
+	}
+	conf := types.Config{Importer: importer.Default()}
+	_, err = conf.Check("main", fset, []*ast.File{f}, nil)
+	return err
+}
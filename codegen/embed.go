@@ -0,0 +1,69 @@
+package codegen
+
+// Embedded receivers
+//
+// Like declared methods, ply methods are promoted from embedded fields:
+//
+//    type Events []Event
+//    type Batch struct{ Events }
+//
+//    errs := b.filter(isError)
+//
+// The generated code operates on slices and maps, not on the structs that
+// embed them, so before anything else is rewritten, the receiver of each
+// promoted ply method is replaced with an explicit selection of the embedded
+// field:
+//
+//    errs := b.Events.filter(isError)
+//
+// The type checker records the path of embedded fields in the selection's
+// index, so no lookup is required. The types of the new expressions are
+// recorded as well, so later passes see an ordinary slice or map receiver.
+
+import (
+	"go/ast"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// selectEmbedded makes the embedded receivers of promoted ply methods
+// explicit, throughout f.
+func selectEmbedded(f *ast.File, info *types.Info) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		fn, ok := n.(*ast.SelectorExpr)
+		if !ok || !isPlyMethod(fn, info.Selections) {
+			return true
+		}
+		index := info.Selections[fn].Index()
+		path := index[:len(index)-1] // the last entry is the ply id
+		if len(path) == 0 {
+			return true
+		}
+
+		recv, typ := fn.X, info.Types[fn.X].Type
+		switch recv.(type) {
+		case *ast.Ident, *ast.SelectorExpr, *ast.CallExpr, *ast.IndexExpr, *ast.ParenExpr:
+		default:
+			// e.g. (&b).filter
+			recv = &ast.ParenExpr{X: recv}
+			info.Types[recv] = types.TypeAndValue{Type: typ}
+		}
+		for _, i := range path {
+			if p, ok := typ.Underlying().(*types.Pointer); ok {
+				typ = p.Elem() // selectors dereference pointers to structs
+			}
+			field := typ.Underlying().(*types.Struct).Field(i)
+			recv = &ast.SelectorExpr{X: recv, Sel: ast.NewIdent(field.Name())}
+			typ = field.Type()
+			info.Types[recv] = types.TypeAndValue{Type: typ}
+		}
+		if p, ok := typ.(*types.Pointer); ok {
+			// an embedded *T; ply methods take their receiver by value
+			recv = &ast.ParenExpr{X: &ast.StarExpr{X: recv}}
+			typ = p.Elem()
+			info.Types[recv] = types.TypeAndValue{Type: typ}
+		}
+		fn.X = recv
+		return true
+	})
+}
@@ -0,0 +1,423 @@
+package codegen
+
+// Compile-time evaluation
+//
+// Some calls can be evaluated at compile time, in which case the call is
+// replaced with its result. max and min are evaluated by the type-checker,
+// like the builtin len. The calls below are evaluated here, because their
+// results are slices or because they take function arguments:
+//
+//    []int{1, 2, 3}.contains(3)                          // true
+//    []int{3, 1, 2}.sort().reverse()                     // []int{3, 2, 1}
+//    []int{1, 2}.all(func(x int) bool { return x > 0 }) // true
+//    enum(1, 4)                                          // []int{1, 2, 3}
+//
+// A receiver can be evaluated if it is a composite literal whose elements are
+// all constants, or another call that can be evaluated. Function arguments
+// must be function literals whose body is a single return statement, using
+// only constants, parameters, and operators. The element type must be an
+// integer, string, or boolean type; floating-point arithmetic is left to run
+// time, where it may be rounded differently.
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"sort"
+	"strconv"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// maxEvalLen is the maximum length of a slice produced by compile-time
+// evaluation. Longer slices are computed at run time instead, to avoid
+// bloating the generated code.
+const maxEvalLen = 1 << 12
+
+// A constSlice is a slice whose elements are known at compile time.
+type constSlice struct {
+	typ   ast.Expr // type of the slice
+	elems []constant.Value
+}
+
+// lit returns a composite literal that evaluates to cs.
+func (cs constSlice) lit() *ast.CompositeLit {
+	elts := make([]ast.Expr, len(cs.elems))
+	for i, v := range cs.elems {
+		elts[i] = ast.NewIdent(v.ExactString())
+	}
+	return &ast.CompositeLit{Type: cs.typ, Elts: elts}
+}
+
+// evaluable reports whether slices with element type T can be evaluated at
+// compile time.
+func evaluable(T types.Type) bool {
+	b, ok := T.Underlying().(*types.Basic)
+	return ok && b.Info()&(types.IsInteger|types.IsString|types.IsBoolean) != 0
+}
+
+// eval evaluates call at compile time, returning an expression for its
+// result. If call cannot be evaluated, eval returns nil.
+func (s specializer) eval(call *ast.CallExpr) ast.Expr {
+	if fn, ok := call.Fun.(*ast.SelectorExpr); ok {
+		switch fn.Sel.Name {
+		case "contains", "all", "any":
+			if v, ok := s.evalBool(call); ok {
+				return ast.NewIdent(v.ExactString())
+			}
+			return nil
+		}
+	}
+	if cs, ok := s.evalSlice(call); ok {
+		return cs.lit()
+	}
+	return nil
+}
+
+// plyMethodCall returns the selector of call if call is a ply method call on
+// a slice, as opposed to a call to a user-defined method of the same name.
+func (s specializer) plyMethodCall(call *ast.CallExpr) (*ast.SelectorExpr, bool) {
+	fn, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil, false
+	}
+	tv, ok := s.types[fn.X]
+	if !ok {
+		return nil, false
+	}
+	if _, ok := tv.Type.Underlying().(*types.Slice); !ok || !isPlyMethod(fn, s.selections) {
+		return nil, false
+	}
+	return fn, true
+}
+
+// evalBool evaluates a call to a ply method that returns a bool.
+func (s specializer) evalBool(call *ast.CallExpr) (constant.Value, bool) {
+	fn, ok := s.plyMethodCall(call)
+	if !ok || len(call.Args) != 1 {
+		return nil, false
+	}
+	recv, ok := s.evalSlice(fn.X)
+	if !ok {
+		return nil, false
+	}
+	switch fn.Sel.Name {
+	case "contains":
+		e := s.types[call.Args[0]].Value
+		if e == nil {
+			return nil, false
+		}
+		for _, v := range recv.elems {
+			if constant.Compare(v, token.EQL, e) {
+				return constant.MakeBool(true), true
+			}
+		}
+		return constant.MakeBool(false), true
+
+	case "all", "any":
+		want := fn.Sel.Name == "any"
+		for _, v := range recv.elems {
+			r, ok := s.evalFunc(call.Args[0], v)
+			if !ok {
+				return nil, false
+			}
+			if constant.BoolVal(r) == want {
+				return constant.MakeBool(want), true
+			}
+		}
+		return constant.MakeBool(!want), true
+	}
+	return nil, false
+}
+
+// evalSlice evaluates e, which must be a composite literal of constants or
+// a call to a ply function or method that returns a slice.
+func (s specializer) evalSlice(e ast.Expr) (constSlice, bool) {
+	switch e := unparen(e).(type) {
+	case *ast.CompositeLit:
+		tv, ok := s.types[e]
+		if !ok || e.Type == nil {
+			return constSlice{}, false
+		}
+		st, ok := tv.Type.Underlying().(*types.Slice)
+		if !ok || !evaluable(st.Elem()) {
+			return constSlice{}, false
+		}
+		elems := make([]constant.Value, len(e.Elts))
+		for i, elt := range e.Elts {
+			if elems[i] = s.types[elt].Value; elems[i] == nil {
+				// also rejects key/value pairs
+				return constSlice{}, false
+			}
+		}
+		return constSlice{e.Type, elems}, true
+
+	case *ast.CallExpr:
+		if fn, ok := e.Fun.(*ast.Ident); ok && fn.Name == "enum" && isPlyFunc(fn, s.uses) {
+			return s.evalEnum(e)
+		}
+		fn, ok := s.plyMethodCall(e)
+		if !ok {
+			return constSlice{}, false
+		}
+		recv, ok := s.evalSlice(fn.X)
+		if !ok {
+			return constSlice{}, false
+		}
+		switch fn.Sel.Name {
+		case "reverse":
+			reversed := make([]constant.Value, len(recv.elems))
+			for i, v := range recv.elems {
+				reversed[len(reversed)-i-1] = v
+			}
+			return constSlice{recv.typ, reversed}, true
+
+		case "sort":
+			sorted := append([]constant.Value(nil), recv.elems...)
+			ok := true
+			less := func(a, b constant.Value) bool { return constant.Compare(a, token.LSS, b) }
+			if len(e.Args) == 1 {
+				less = func(a, b constant.Value) bool {
+					r, rok := s.evalFunc(e.Args[0], a, b)
+					ok = ok && rok
+					return rok && constant.BoolVal(r)
+				}
+			}
+			// a stable sort is a valid result of an unstable sort
+			sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+			return constSlice{recv.typ, sorted}, ok
+
+		case "uniq":
+			var unique []constant.Value
+		outer:
+			for _, v := range recv.elems {
+				for _, u := range unique {
+					if constant.Compare(u, token.EQL, v) {
+						continue outer
+					}
+				}
+				unique = append(unique, v)
+			}
+			return constSlice{recv.typ, unique}, true
+
+		case "take", "drop":
+			n, ok := constant.Int64Val(s.constArg(e, 0))
+			if !ok || n < 0 {
+				// negative n panics at run time
+				return constSlice{}, false
+			}
+			if n > int64(len(recv.elems)) {
+				n = int64(len(recv.elems))
+			}
+			if fn.Sel.Name == "take" {
+				return constSlice{recv.typ, recv.elems[:n]}, true
+			}
+			return constSlice{recv.typ, recv.elems[n:]}, true
+		}
+	}
+	return constSlice{}, false
+}
+
+// evalEnum evaluates a call to enum with constant arguments.
+func (s specializer) evalEnum(call *ast.CallExpr) (constSlice, bool) {
+	st, ok := s.types[call].Type.(*types.Slice)
+	if !ok {
+		return constSlice{}, false
+	}
+	T, ok := st.Elem().(*types.Basic)
+	if !ok {
+		return constSlice{}, false
+	}
+	args := make([]int64, len(call.Args))
+	for i := range call.Args {
+		if args[i], ok = constant.Int64Val(s.constArg(call, i)); !ok {
+			return constSlice{}, false
+		}
+	}
+	// normalize to enum(x, y, step)
+	var x, y, step int64
+	switch len(args) {
+	case 1:
+		x, y, step = 0, args[0], 1
+	case 2:
+		x, y, step = args[0], args[1], 1
+	case 3:
+		x, y, step = args[0], args[1], args[2]
+	default:
+		return constSlice{}, false
+	}
+	// non-terminating enums panic at run time
+	if step == 0 || (x < y && step < 0) || (x > y && step > 0) || (len(args) < 3 && x > y) {
+		return constSlice{}, false
+	}
+	var elems []constant.Value
+	for i := x; (x < y && i < y) || (x > y && i > y); i += step {
+		if len(elems) == maxEvalLen {
+			return constSlice{}, false
+		}
+		elems = append(elems, constant.MakeInt64(i))
+	}
+	return constSlice{&ast.ArrayType{Elt: ast.NewIdent(T.Name())}, elems}, true
+}
+
+// constArg returns the value of the i'th argument of call, or an unknown
+// value if it is not a constant.
+func (s specializer) constArg(call *ast.CallExpr, i int) constant.Value {
+	if v := s.types[call.Args[i]].Value; v != nil {
+		return v
+	}
+	return constant.MakeUnknown()
+}
+
+// evalFunc evaluates a call to fn, which must be a function literal whose
+// body is a single return statement.
+func (s specializer) evalFunc(fn ast.Expr, args ...constant.Value) (constant.Value, bool) {
+	lit, ok := unparen(fn).(*ast.FuncLit)
+	if !ok || len(lit.Body.List) != 1 {
+		return nil, false
+	}
+	ret, ok := lit.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil, false
+	}
+	env := make(map[string]constant.Value)
+	var i int
+	for _, field := range lit.Type.Params.List {
+		if len(field.Names) == 0 {
+			i++
+			continue
+		}
+		for _, name := range field.Names {
+			if i >= len(args) {
+				return nil, false
+			}
+			env[name.Name] = args[i]
+			i++
+		}
+	}
+	if i != len(args) {
+		return nil, false
+	}
+	return s.evalExpr(ret.Results[0], env)
+}
+
+// evalExpr evaluates e, whose free variables are bound by env.
+func (s specializer) evalExpr(e ast.Expr, env map[string]constant.Value) (constant.Value, bool) {
+	if v := s.types[e].Value; v != nil {
+		return v, true
+	}
+	var v constant.Value
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return s.evalExpr(e.X, env)
+
+	case *ast.Ident:
+		v, ok := env[e.Name]
+		return v, ok
+
+	case *ast.UnaryExpr:
+		x, ok := s.evalExpr(e.X, env)
+		if !ok {
+			return nil, false
+		}
+		switch e.Op {
+		case token.ADD, token.SUB, token.NOT:
+			v = constant.UnaryOp(e.Op, x, 0)
+		default:
+			return nil, false
+		}
+
+	case *ast.BinaryExpr:
+		x, ok := s.evalExpr(e.X, env)
+		if !ok {
+			return nil, false
+		}
+		y, ok := s.evalExpr(e.Y, env)
+		if !ok {
+			return nil, false
+		}
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			v = constant.MakeBool(constant.Compare(x, e.Op, y))
+		case token.SHL, token.SHR:
+			n, ok := constant.Uint64Val(y)
+			if !ok || n > 64 {
+				return nil, false
+			}
+			v = constant.Shift(x, e.Op, uint(n))
+		case token.QUO, token.REM:
+			if y.Kind() != constant.Int || constant.Sign(y) == 0 {
+				return nil, false
+			}
+			op := e.Op
+			if op == token.QUO {
+				op = token.QUO_ASSIGN // integer division
+			}
+			v = constant.BinaryOp(x, op, y)
+		case token.ADD, token.SUB, token.MUL, token.AND, token.OR, token.XOR, token.AND_NOT, token.LAND, token.LOR:
+			v = constant.BinaryOp(x, e.Op, y)
+		default:
+			return nil, false
+		}
+
+	default:
+		return nil, false
+	}
+	// the result must not overflow its type
+	return v, representable(v, s.types[e].Type)
+}
+
+// representable reports whether v can be represented by a value of type T
+// at run time. Only integer, string, and boolean types are supported.
+func representable(v constant.Value, T types.Type) bool {
+	if T == nil {
+		return false
+	}
+	b, ok := T.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	switch info := b.Info(); {
+	case info&types.IsBoolean != 0:
+		return v.Kind() == constant.Bool
+	case info&types.IsString != 0:
+		return v.Kind() == constant.String
+	case info&types.IsInteger == 0 || v.Kind() != constant.Int:
+		return false
+	case info&types.IsUntyped != 0:
+		return true
+	}
+
+	var bits uint
+	switch b.Kind() {
+	case types.Int8, types.Uint8:
+		bits = 8
+	case types.Int16, types.Uint16:
+		bits = 16
+	case types.Int32, types.Uint32:
+		bits = 32
+	case types.Int64, types.Uint64:
+		bits = 64
+	default: // int, uint, uintptr
+		bits = strconv.IntSize
+	}
+	one := constant.MakeInt64(1)
+	if b.Info()&types.IsUnsigned != 0 {
+		max := constant.Shift(one, token.SHL, bits)
+		return constant.Sign(v) >= 0 && constant.Compare(v, token.LSS, max)
+	}
+	max := constant.Shift(one, token.SHL, bits-1)
+	min := constant.UnaryOp(token.SUB, max, 0)
+	return constant.Compare(v, token.GEQ, min) && constant.Compare(v, token.LSS, max)
+}
+
+// unparen removes any parentheses surrounding e.
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
@@ -0,0 +1,49 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestEval checks that calls are replaced with their results when they can
+// be evaluated at compile time, and left alone otherwise.
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string // empty if the call should not be evaluated
+	}{
+		{`[]int{1, 2, 3}.contains(3)`, `true`},
+		{`[]string{"a", "b"}.contains("c")`, `false`},
+		{`[]int{3, 1, 2, 3}.sort().reverse()`, `[]int{3, 3, 2, 1}`},
+		{`[]int{3, 1, 2}.sort(func(x, y int) bool { return x > y })`, `[]int{3, 2, 1}`},
+		{`[]int{1, 2, 1, 3}.uniq().drop(1).take(5)`, `[]int{2, 3}`},
+		{`[]int{1, 2}.all(func(x int) bool { return x > 0 })`, `true`},
+		{`[]int{1, 2}.any(func(x int) bool { return x/2 == 1 })`, `true`},
+		{`enum(1, 10, 3)`, `[]int{1, 4, 7}`},
+		{`ints{2, 1}.sort()`, `ints{1, 2}`},
+
+		// not evaluated
+		{`[]int{1, 2}.contains(v)`, ``},
+		{`[]int{1, v}.reverse()`, ``},
+		{`[]int8{100}.all(func(x int8) bool { return x+x > 0 })`, ``}, // overflows
+		{`[]int{1}.all(func(x int) bool { return x > v })`, ``},
+		{`[]float64{0.1, 0.2}.sort()`, ``},
+		{`[]int{1}.take(-1)`, ``},
+		{`enum(5, 1)`, ``},
+	}
+
+	for _, test := range tests {
+		code, err := compileMain(nil, "type ints []int\n\nvar v = 1\n", "\t_ = "+test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if test.exp == "" {
+			if !strings.Contains(code, "__ply") {
+				t.Errorf("%s: expected call to be left alone, got:\n%s", test.expr, code)
+			}
+		} else if !strings.Contains(code, "_ = "+test.exp+"\n") {
+			t.Errorf("%s: expected %s, got:\n%s", test.expr, test.exp, code)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package codegen
+
+// Statement fusion
+//
+// Pipelining only combines the calls of a single expression, but chains are
+// often split across statements for readability:
+//
+//    evens := xs.filter(even)
+//    sq := evens.morph(square)
+//    total := sq.fold(add)
+//
+// If an intermediate variable is declared with the result of a ply method,
+// used exactly once, and that use is the receiver of a ply method in the very
+// next statement, the declaration can be substituted for the use without
+// changing the meaning of the program. Applied repeatedly, the statements
+// above become
+//
+//    total := xs.filter(even).morph(square).fold(add)
+//
+// which is then pipelined as usual. The next statement must consist of the
+// chain alone (e.g. an assignment to plain identifiers, or a return of a
+// single value), so that nothing is evaluated between the declaration and its
+// use. Fusion happens before any code is generated, so the types of the
+// substituted expressions are already known.
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// fuse substitutes single-use intermediates into the statements that follow
+// them, throughout f.
+func fuse(f *ast.File, info *types.Info) {
+	uses := make(map[types.Object]int)
+	for _, obj := range info.Uses {
+		uses[obj]++
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = fuseStmts(n.List, uses, info)
+		case *ast.CaseClause:
+			n.Body = fuseStmts(n.Body, uses, info)
+		case *ast.CommClause:
+			n.Body = fuseStmts(n.Body, uses, info)
+		}
+		return true
+	})
+}
+
+// fuseStmts fuses adjacent statements in list, returning the new list.
+func fuseStmts(list []ast.Stmt, uses map[types.Object]int, info *types.Info) []ast.Stmt {
+	fused := list[:0]
+	for _, stmt := range list {
+		if n := len(fused); n > 0 {
+			obj, value := intermediate(fused[n-1], uses, info)
+			if recv := chainRecv(stmt, info); obj != nil && recv != nil {
+				if id, ok := recv.X.(*ast.Ident); ok && info.Uses[id] == obj {
+					recv.X = value
+					fused[n-1] = stmt
+					continue
+				}
+			}
+		}
+		fused = append(fused, stmt)
+	}
+	return fused
+}
+
+// intermediate returns the variable declared by stmt and the value it is
+// initialized with, if stmt declares a single variable that is used exactly
+// once and is initialized with the result of a ply method that can be
+// pipelined.
+func intermediate(stmt ast.Stmt, uses map[types.Object]int, info *types.Info) (types.Object, ast.Expr) {
+	var name *ast.Ident
+	var value ast.Expr
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		if stmt.Tok != token.DEFINE || len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
+			return nil, nil
+		}
+		name, _ = stmt.Lhs[0].(*ast.Ident)
+		value = stmt.Rhs[0]
+	case *ast.DeclStmt:
+		d, ok := stmt.Decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.VAR || len(d.Specs) != 1 {
+			return nil, nil
+		}
+		// an explicit type may differ from the type of the value
+		spec := d.Specs[0].(*ast.ValueSpec)
+		if spec.Type != nil || len(spec.Names) != 1 || len(spec.Values) != 1 {
+			return nil, nil
+		}
+		name, value = spec.Names[0], spec.Values[0]
+	}
+	if name == nil {
+		return nil, nil
+	}
+	obj := info.Defs[name]
+	if obj == nil || uses[obj] != 1 {
+		return nil, nil
+	}
+	call, ok := value.(*ast.CallExpr)
+	if !ok {
+		return nil, nil
+	}
+	if fn, ok := call.Fun.(*ast.SelectorExpr); !ok || !canPipelineCall(fn, info) {
+		return nil, nil
+	}
+	return obj, value
+}
+
+// chainRecv returns the innermost selector of the chain of ply method calls
+// that constitutes stmt, if its method can be pipelined. The receiver of the
+// selector is the first expression evaluated by stmt.
+func chainRecv(stmt ast.Stmt, info *types.Info) *ast.SelectorExpr {
+	var e ast.Expr
+	switch stmt := stmt.(type) {
+	case *ast.AssignStmt:
+		// the operands of index expressions on the left-hand side would be
+		// evaluated first
+		for _, lhs := range stmt.Lhs {
+			if _, ok := lhs.(*ast.Ident); !ok {
+				return nil
+			}
+		}
+		if len(stmt.Rhs) == 1 {
+			e = stmt.Rhs[0]
+		}
+	case *ast.ExprStmt:
+		e = stmt.X
+	case *ast.ReturnStmt:
+		if len(stmt.Results) == 1 {
+			e = stmt.Results[0]
+		}
+	case *ast.DeclStmt:
+		if d, ok := stmt.Decl.(*ast.GenDecl); ok && d.Tok == token.VAR && len(d.Specs) == 1 {
+			if spec := d.Specs[0].(*ast.ValueSpec); len(spec.Values) == 1 {
+				e = spec.Values[0]
+			}
+		}
+	}
+
+	var recv *ast.SelectorExpr
+	for {
+		call, ok := e.(*ast.CallExpr)
+		if !ok {
+			break
+		}
+		fn, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			break
+		}
+		recv, e = fn, fn.X
+	}
+	if recv == nil || !canPipelineCall(recv, info) {
+		return nil
+	}
+	return recv
+}
+
+// canPipelineCall reports whether fn is a ply method that can be pipelined.
+func canPipelineCall(fn *ast.SelectorExpr, info *types.Info) bool {
+	tv, ok := info.Types[fn.X]
+	if !ok || tv.Type == nil || !isPlyMethod(fn, info.Selections) {
+		return false
+	}
+	switch tv.Type.Underlying().(type) {
+	case *types.Slice:
+		return CanPipeline(fn.Sel.Name, false)
+	case *types.Map:
+		return CanPipeline(fn.Sel.Name, true)
+	}
+	return false
+}
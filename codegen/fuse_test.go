@@ -0,0 +1,64 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFuse checks that single-use intermediates are substituted into the
+// statement that follows them, and left alone otherwise.
+func TestFuse(t *testing.T) {
+	tests := []struct {
+		stmts string
+		fused bool
+		args  string // the arguments passed to the fused pipeline
+	}{
+		{`evens := xs.filter(even); sq := evens.morph(square); r = sq.fold(add)`, true, `even, square, add`},
+		{`var evens = xs.filter(even); r = evens.fold(add)`, true, `even, add`},
+		{`evens := xs.filter(even); evens.foreach(print)`, true, `even, print`},
+		{`evens := m.filter(func(k, v int) bool { return k > v }); ys = evens.keys()`, true, ``}, // the callback is inlined
+
+		// not fused
+		{`evens := xs.filter(even); r = evens.fold(add) + len(evens)`, false, ``},                        // used twice
+		{`evens := xs.filter(even); print(0); r = evens.fold(add)`, false, ``},                           // not adjacent
+		{`evens := xs.filter(even); r = len(evens)`, false, ``},                                          // not a ply method
+		{`evens := xs.filter(even); r = f() + evens.fold(add)`, false, ``},                               // f is called first
+		{`evens := xs.filter(even); zs[f()] = evens.fold(add)`, false, ``},                               // ditto
+		{`var evens []int = xs.filter(even); r = evens.fold(add)`, false, ``},                            // explicit type
+		{`evens := xs.filter(even); r = evens.sort(func(a, b int) bool { return a < b })[0]`, false, ``}, // sort cannot be pipelined
+	}
+
+	decls := `
+func even(x int) bool { return x%2 == 0 }
+
+func square(x int) int { return x * x }
+
+func add(a, b int) int { return a + b }
+
+func print(x int) {}
+
+func f() int { return 0 }
+`
+	for _, test := range tests {
+		code, err := compileMain(nil, decls, `
+	xs := []int{1, 2, 3}
+	zs := []int{0}
+	m := map[int]int{1: 2}
+	var r int
+	var ys []int
+	_, _, _, _, _ = xs, zs, m, r, ys
+	`+test.stmts)
+		if err != nil {
+			t.Errorf("%s: %v", test.stmts, err)
+			continue
+		}
+		declared := strings.Contains(code, "evens :=") || strings.Contains(code, "var evens")
+		if !test.fused {
+			if !declared {
+				t.Errorf("%s: expected statements to be left alone, got:\n%s", test.stmts, code)
+			}
+		} else if args, ok := pipelineArgs(code); declared || !ok || args != test.args {
+			t.Errorf("%s: expected fused pipeline(%s), got:\n%s", test.stmts, test.args, code)
+		}
+	}
+}
@@ -31,52 +31,109 @@ func rewriteMethod(name string) rewriter {
 	}
 }
 
-var funcGenerators = map[string]func(*ast.Ident, []ast.Expr, map[ast.Expr]types.TypeAndValue) (string, string, rewriter){
-	"enum":  enumGen,
-	"max":   maxGen,
-	"merge": mergeGen,
-	"min":   minGen,
-	"not":   notGen,
-	"zip":   zipGen,
-}
-
-var methodGenerators = map[string]func(*ast.SelectorExpr, []ast.Expr, map[ast.Expr]types.TypeAndValue) (string, string, rewriter){
-	"all":       genSliceMethod(allTempl, "all_slice"),
-	"any":       genSliceMethod(anyTempl, "any_slice"),
-	"contains":  containsGen,
-	"drop":      genSliceMethod(dropTempl, "drop_slice"),
-	"dropWhile": genSliceMethod(dropWhileTempl, "dropWhile_slice"),
-	"elems":     elemsGen,
-	"filter":    filterGen,
-	"fold":      foldGen,
-	"foreach":   genSliceMethod(foreachTempl, "foreach_slice"),
-	"keys":      keysGen,
-	"morph":     morphGen,
-	"reverse":   genSliceMethod(reverseTempl, "reverse_slice"),
-	"sort":      sortGen,
-	"take":      genSliceMethod(takeTempl, "take_slice"),
-	"takeWhile": genSliceMethod(takeWhileTempl, "takeWhile_slice"),
-	"tee":       genSliceMethod(teeTempl, "tee_slice"),
-	"toMap":     toMapGen,
-	"toSet":     genSliceMethod(toSetTempl, "toSet_slice"),
-	"uniq":      genSliceMethod(uniqTempl, "uniq_slice"),
-}
-
-var safeFnName = func() func(string) string {
-	count := 0
-	return func(name string) string {
-		count++
-		return "__plyfn_" + strconv.Itoa(count) + "_" + name
-	}
-}()
-
-var safeTypeName = func() func(string) string {
-	count := 0
-	return func(name string) string {
-		count++
-		return "__plytype_" + strconv.Itoa(count) + "_" + name
-	}
-}()
+var funcGenerators = map[string]func(*namer, *ast.Ident, []ast.Expr, map[ast.Expr]types.TypeAndValue) (string, string, rewriter){
+	"compose":     composeGen,
+	"enum":        enumGen,
+	"fromEntries": fromEntriesGen,
+	"max":         maxGen,
+	"merge":       mergeGen,
+	"min":         minGen,
+	"not":         notGen,
+	"zip":         zipGen,
+}
+
+var methodGenerators = map[string]func(*namer, *ast.SelectorExpr, []ast.Expr, map[ast.Expr]types.TypeAndValue) (string, string, rewriter){
+	"all":                 genSliceMethod(allTempl, "all_slice"),
+	"alli":                genSliceMethod(alliTempl, "alli_slice"),
+	"any":                 genSliceMethod(anyTempl, "any_slice"),
+	"anyi":                genSliceMethod(anyiTempl, "anyi_slice"),
+	"argmax":              genSliceMethod(argmaxTempl, "argmax_slice"),
+	"argmin":              genSliceMethod(argminTempl, "argmin_slice"),
+	"binarySearch":        genSortedMethod(binarySearchTempl, "binarySearch", 1),
+	"chunk":               genSliceMethod(chunkTempl, "chunk_slice"),
+	"contains":            containsGen,
+	"count":               genSliceMethod(countTempl, "count_slice"),
+	"countBy":             countByGen,
+	"dedupSorted":         genSortedMethod(dedupSortedTempl, "dedupSorted", 0),
+	"difference":          genSetMethod(differenceTempl, differenceMapTempl, "difference"),
+	"drop":                genSliceMethod(dropTempl, "drop_slice"),
+	"dropWhile":           genSliceMethod(dropWhileTempl, "dropWhile_slice"),
+	"elems":               elemsGen,
+	"entries":             entriesGen,
+	"equal":               genSetMethod(equalTempl, equalMapTempl, "equal"),
+	"filter":              filterGen,
+	"filteri":             genSliceMethod(filteriTempl, "filteri_slice"),
+	"find":                genSliceMethod(findTempl, "find_slice"),
+	"findIndex":           genSliceMethod(findIndexTempl, "findIndex_slice"),
+	"flatMorph":           flatMorphGen,
+	"flatten":             flattenGen,
+	"fold":                foldGen,
+	"foldi":               foldiGen,
+	"foreach":             genSliceMethod(foreachTempl, "foreach_slice"),
+	"foreachi":            genSliceMethod(foreachiTempl, "foreachi_slice"),
+	"groupBy":             groupByGen,
+	"indexOf":             indexOfGen,
+	"insertSorted":        genSortedMethod(insertSortedTempl, "insertSorted", 1),
+	"intersect":           genSetMethod(intersectTempl, intersectMapTempl, "intersect"),
+	"invert":              invertGen,
+	"isSubset":            genSetMethod(isSubsetTempl, isSubsetMapTempl, "isSubset"),
+	"isSuperset":          genSetMethod(isSupersetTempl, isSupersetMapTempl, "isSuperset"),
+	"keys":                keysGen,
+	"lastIndexOf":         lastIndexOfGen,
+	"mapKeys":             mapKeysGen,
+	"mapValues":           mapValuesGen,
+	"max":                 genSliceMethod(maxSliceTempl, "max_slice"),
+	"maxBy":               maxByGen,
+	"mean":                genSliceMethod(meanTempl, "mean_slice"),
+	"mergeSorted":         genSortedMethod(mergeSortedTempl, "mergeSorted", 1),
+	"min":                 genSliceMethod(minSliceTempl, "min_slice"),
+	"minBy":               minByGen,
+	"morph":               morphGen,
+	"morphi":              morphiGen,
+	"pairs":               genSliceMethod(pairsTempl, "pairs_slice"),
+	"partition":           genSliceMethod(partitionTempl, "partition_slice"),
+	"product":             genSliceMethod(productTempl, "product_slice"),
+	"reverse":             genSliceMethod(reverseTempl, "reverse_slice"),
+	"sort":                sortGen,
+	"sortBy":              sortByGen,
+	"sortDesc":            sortDescGen,
+	"sortStable":          sortStableGen,
+	"sortedEntries":       sortedEntriesGen,
+	"sum":                 genSliceMethod(sumTempl, "sum_slice"),
+	"symmetricDifference": genSetMethod(symmetricDifferenceTempl, symmetricDifferenceMapTempl, "symmetricDifference"),
+	"take":                genSliceMethod(takeTempl, "take_slice"),
+	"takeWhile":           genSliceMethod(takeWhileTempl, "takeWhile_slice"),
+	"tee":                 genSliceMethod(teeTempl, "tee_slice"),
+	"toMap":               toMapGen,
+	"toSet":               genSliceMethod(toSetTempl, "toSet_slice"),
+	"union":               genSetMethod(unionTempl, unionMapTempl, "union"),
+	"uniq":                genSliceMethod(uniqTempl, "uniq_slice"),
+	"window":              genSliceMethod(windowTempl, "window_slice"),
+}
+
+// A namer generates unique identifiers for specialized functions and types.
+// Identifiers only need to be unique within a package, so each compilation
+// uses its own namer; this allows packages to be compiled concurrently.
+type namer struct {
+	fns   int
+	types int
+	pipes int
+}
+
+func (n *namer) fnName(name string) string {
+	n.fns++
+	return "__plyfn_" + strconv.Itoa(n.fns) + "_" + name
+}
+
+func (n *namer) typeName(name string) string {
+	n.types++
+	return "__plytype_" + strconv.Itoa(n.types) + "_" + name
+}
+
+func (n *namer) pipeName() string {
+	n.pipes++
+	return "__plypipe_" + strconv.Itoa(n.pipes)
+}
 
 func specify(templ, name string, typs ...types.Type) string {
 	code := strings.Replace(templ, "#name", name, -1)
@@ -87,25 +144,60 @@ func specify(templ, name string, typs ...types.Type) string {
 	return code
 }
 
-func genFunc(templ, fnname string, typs ...types.Type) (name, code string, r rewriter) {
-	name = safeFnName(fnname)
+func genFunc(n *namer, templ, fnname string, typs ...types.Type) (name, code string, r rewriter) {
+	name = n.fnName(fnname)
 	code = specify(templ, name, typs...)
 	r = rewriteFunc(name)
 	return
 }
 
-func genMethod(templ, methodname string, typs ...types.Type) (name, code string, r rewriter) {
-	name = safeTypeName(methodname)
+func genMethod(n *namer, templ, methodname string, typs ...types.Type) (name, code string, r rewriter) {
+	name = n.typeName(methodname)
 	code = specify(templ, name, typs...)
 	r = rewriteMethod(name)
 	return
 }
 
 // for slice methods that just need T
-func genSliceMethod(templ, methodname string) func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
-	return func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func genSliceMethod(templ, methodname string) func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	return func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 		T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
-		return genMethod(templ, methodname, T)
+		return genMethod(n, templ, methodname, T)
+	}
+}
+
+// setHasStructTempl and setHasBoolTempl are appended to the map templates of
+// set methods. For sets with element type bool, only the keys mapped to true
+// are members.
+const setHasStructTempl = `
+func (m #name) has(k #T) bool {
+	_, ok := m[k]
+	return ok
+}
+`
+
+const setHasBoolTempl = `
+func (m #name) has(k #T) bool {
+	return m[k]
+}
+`
+
+// for set methods, which have a slice form and a map form
+func genSetMethod(sliceTempl, mapTempl, methodname string) func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	return func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+		switch typ := exprTypes[fn.X].Type.Underlying().(type) {
+		case *types.Slice:
+			return genMethod(n, sliceTempl, methodname+"_slice", typ.Elem())
+		case *types.Map:
+			templ, member := mapTempl+setHasStructTempl, "struct{}{}"
+			if b, ok := typ.Elem().Underlying().(*types.Basic); ok && b.Info()&types.IsBoolean != 0 {
+				templ, member = mapTempl+setHasBoolTempl, "true"
+			}
+			name, code, r = genMethod(n, templ, methodname+"_map", typ.Key(), typ.Elem())
+			code = strings.Replace(code, "#member", member, -1)
+			return
+		}
+		return
 	}
 }
 
@@ -148,19 +240,35 @@ func #name(x #T) []#T {
 }
 `
 
-func enumGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func enumGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	T := exprTypes[args[0]].Type
 	switch len(args) {
 	case 3:
-		return genFunc(enumTempl, "enum", T)
+		return genFunc(n, enumTempl, "enum", T)
 	case 2:
-		return genFunc(enum2Templ, "enum", T)
+		return genFunc(n, enum2Templ, "enum", T)
 	case 1:
-		return genFunc(enum1Templ, "enum", T)
+		return genFunc(n, enum1Templ, "enum", T)
 	}
 	return
 }
 
+const fromEntriesTempl = `
+func #name(es []#T) map[#U]#V {
+	m := make(map[#U]#V, len(es))
+	for _, e := range es {
+		m[e.Key] = e.Val
+	}
+	return m
+}
+`
+
+func fromEntriesGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	E := exprTypes[args[0]].Type.Underlying().(*types.Slice).Elem()
+	st := E.Underlying().(*types.Struct)
+	return genFunc(n, fromEntriesTempl, "fromEntries", E, st.Field(0).Type(), st.Field(1).Type())
+}
+
 const maxTempl = `
 func #name(a, b #T) #T {
 	if a > b {
@@ -170,9 +278,9 @@ func #name(a, b #T) #T {
 }
 `
 
-func maxGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func maxGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	T := exprTypes[args[0]].Type
-	return genFunc(maxTempl, "max", T)
+	return genFunc(n, maxTempl, "max", T)
 }
 
 const mergeTempl = `
@@ -191,7 +299,7 @@ func #name(recv map[#T]#U, rest ...map[#T]#U) map[#T]#U {
 }
 `
 
-func mergeGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func mergeGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	// seek until we find a non-nil arg
 	var mt *types.Map
 	for _, arg := range args {
@@ -200,7 +308,7 @@ func mergeGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeA
 			break
 		}
 	}
-	return genFunc(mergeTempl, "merge", mt.Key(), mt.Elem())
+	return genFunc(n, mergeTempl, "merge", mt.Key(), mt.Elem())
 }
 
 const minTempl = `
@@ -212,31 +320,67 @@ func #name(a, b #T) #T {
 }
 `
 
-func minGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func minGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	T := exprTypes[args[0]].Type
-	return genFunc(minTempl, "min", T)
+	return genFunc(n, minTempl, "min", T)
 }
 
 const notTempl = `
 func #name(fn #T) #T {
-	return #T {
+	return func(#params) #U {
 		return !fn(#args)
 	}
 }
 `
 
-func notGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
-	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
-	callArgs := make([]string, sig.Params().Len())
-	for i := range callArgs {
-		callArgs[i] = sig.Params().At(i).Name()
+func notGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[args[0]].Type
+	sig := T.Underlying().(*types.Signature)
+	name, code, r = genFunc(n, notTempl, "not", T, sig.Results().At(0).Type())
+	// not requires an additional rewrite for the parameters
+	code = replaceParams(code, sig)
+	return
+}
+
+const composeTempl = `
+func #name(f #T, g #U) #V {
+	return func(#params) #W {
+		return f(g(#args))
 	}
-	name, code, r = genFunc(notTempl, "not", sig)
-	// not requires an additional rewrite for the arguments
-	code = strings.Replace(code, "#args", strings.Join(callArgs, ", "), -1)
+}
+`
+
+func composeGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[args[0]].Type
+	U := exprTypes[args[1]].Type
+	sig := U.Underlying().(*types.Signature)
+	R := T.Underlying().(*types.Signature).Results().At(0).Type()
+	V := types.NewSignature(nil, sig.Params(), types.NewTuple(types.NewVar(0, nil, "", R)), sig.Variadic())
+	name, code, r = genFunc(n, composeTempl, "compose", T, U, V, R)
+	code = replaceParams(code, sig)
 	return
 }
 
+// replaceParams replaces #params and #args with the parameter list and call
+// arguments of a function with signature sig. The parameters are given fresh
+// names, since those of sig may be blank or missing.
+func replaceParams(code string, sig *types.Signature) string {
+	params := make([]string, sig.Params().Len())
+	args := make([]string, sig.Params().Len())
+	for i := range params {
+		args[i] = "a" + strconv.Itoa(i)
+		t := sig.Params().At(i).Type()
+		if sig.Variadic() && i == len(params)-1 {
+			params[i] = args[i] + " ..." + t.(*types.Slice).Elem().String()
+			args[i] += "..."
+		} else {
+			params[i] = args[i] + " " + t.String()
+		}
+	}
+	code = strings.Replace(code, "#params", strings.Join(params, ", "), -1)
+	return strings.Replace(code, "#args", strings.Join(args, ", "), -1)
+}
+
 const zipTempl = `
 func #name(fn func(a #T, b #U) #V, a []#T, b []#U) []#V {
 	var zipped []#V
@@ -252,13 +396,13 @@ func #name(fn func(a #T, b #U) #V, a []#T, b []#U) []#V {
 }
 `
 
-func zipGen(fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func zipGen(n *namer, fn *ast.Ident, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	// determine arg types
-	sig := exprTypes[args[0]].Type.(*types.Signature)
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
 	T := sig.Params().At(0).Type()
 	U := sig.Params().At(1).Type()
 	V := sig.Results().At(0).Type()
-	return genFunc(zipTempl, "zip", T, U, V)
+	return genFunc(n, zipTempl, "zip", T, U, V)
 }
 
 const allTempl = `
@@ -274,6 +418,19 @@ func (xs #name) all(pred func(#T) bool) bool {
 }
 `
 
+const alliTempl = `
+type #name []#T
+
+func (xs #name) alli(pred func(int, #T) bool) bool {
+	for i, x := range xs {
+		if !pred(i, x) {
+			return false
+		}
+	}
+	return true
+}
+`
+
 const anyTempl = `
 type #name []#T
 
@@ -287,6 +444,68 @@ func (xs #name) any(pred func(#T) bool) bool {
 }
 `
 
+const anyiTempl = `
+type #name []#T
+
+func (xs #name) anyi(pred func(int, #T) bool) bool {
+	for i, x := range xs {
+		if pred(i, x) {
+			return true
+		}
+	}
+	return false
+}
+`
+
+// argmaxTempl, maxSliceTempl, etc. order NaNs before all other values, for
+// consistency with sort.
+const argmaxTempl = `
+type #name []#T
+
+func (xs #name) argmax() int {
+	argmax := -1
+	for i, x := range xs {
+		if argmax == -1 || xs[argmax] < x || (xs[argmax] != xs[argmax] && x == x) {
+			argmax = i
+		}
+	}
+	return argmax
+}
+`
+
+const argminTempl = `
+type #name []#T
+
+func (xs #name) argmin() int {
+	argmin := -1
+	for i, x := range xs {
+		if argmin == -1 || x < xs[argmin] || (x != x && xs[argmin] == xs[argmin]) {
+			argmin = i
+		}
+	}
+	return argmin
+}
+`
+
+const chunkTempl = `
+type #name []#T
+
+func (xs #name) chunk(n int) [][]#T {
+	if n <= 0 {
+		panic("chunk: size must be positive")
+	}
+	chunks := make([][]#T, 0, (len(xs)+n-1)/n)
+	for i := 0; i < len(xs); i += n {
+		j := i + n
+		if j > len(xs) {
+			j = len(xs)
+		}
+		chunks = append(chunks, xs[i:j:j])
+	}
+	return chunks
+}
+`
+
 const containsSliceTempl = `
 type #name []#T
 
@@ -322,22 +541,88 @@ func (m #name) contains(e #T) bool {
 }
 `
 
-func containsGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func containsGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	switch typ := exprTypes[fn.X].Type.Underlying().(type) {
 	case *types.Slice:
 		if T := typ.Elem(); !types.Comparable(T) {
 			// if type is not comparable, then the argument must be nil
 			// (otherwise type-check would have failed)
-			return genMethod(containsSliceNilTempl, "contains_slice_nil", T)
+			return genMethod(n, containsSliceNilTempl, "contains_slice_nil", T)
 		} else {
-			return genMethod(containsSliceTempl, "contains_slice", T)
+			return genMethod(n, containsSliceTempl, "contains_slice", T)
 		}
 	case *types.Map:
-		return genMethod(containsMapTempl, "contains_map", typ.Key(), typ.Elem())
+		return genMethod(n, containsMapTempl, "contains_map", typ.Key(), typ.Elem())
 	}
 	return
 }
 
+const countTempl = `
+type #name []#T
+
+func (xs #name) count(pred func(#T) bool) int {
+	var count int
+	for _, x := range xs {
+		if pred(x) {
+			count++
+		}
+	}
+	return count
+}
+`
+
+const countByTempl = `
+type #name []#T
+
+func (xs #name) countBy(key func(#T) #U) map[#U]int {
+	counts := make(map[#U]int)
+	for _, x := range xs {
+		counts[key(x)]++
+	}
+	return counts
+}
+`
+
+func countByGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	// determine arg types
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	U := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+	return genMethod(n, countByTempl, "countBy_slice", T, U)
+}
+
+const differenceTempl = `
+type #name []#T
+
+func (xs #name) difference(ys []#T) []#T {
+	exclude := make(map[#T]struct{}, len(ys))
+	for _, y := range ys {
+		exclude[y] = struct{}{}
+	}
+	#alloc(diff, []#T, upper, len(xs))
+	for _, x := range xs {
+		if _, ok := exclude[x]; !ok {
+			diff = append(diff, x)
+			exclude[x] = struct{}{}
+		}
+	}
+	return diff
+}
+`
+
+const differenceMapTempl = `
+type #name map[#T]#U
+
+func (m #name) difference(other map[#T]#U) map[#T]#U {
+	diff := make(map[#T]#U)
+	for k := range m {
+		if m.has(k) && !#name(other).has(k) {
+			diff[k] = #member
+		}
+	}
+	return diff
+}
+`
+
 const dropTempl = `
 type #name []#T
 
@@ -375,16 +660,70 @@ func (m #name) elems() []#U {
 }
 `
 
-func elemsGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func elemsGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	return genMethod(n, elemsTempl, "elems_map", mt.Key(), mt.Elem())
+}
+
+const equalTempl = `
+type #name []#T
+
+func (xs #name) equal(ys []#T) bool {
+	xset := make(map[#T]struct{}, len(xs))
+	for _, x := range xs {
+		xset[x] = struct{}{}
+	}
+	yset := make(map[#T]struct{}, len(ys))
+	for _, y := range ys {
+		if _, ok := xset[y]; !ok {
+			return false
+		}
+		yset[y] = struct{}{}
+	}
+	return len(xset) == len(yset)
+}
+`
+
+const equalMapTempl = `
+type #name map[#T]#U
+
+func (m #name) equal(other map[#T]#U) bool {
+	for k := range m {
+		if m.has(k) && !#name(other).has(k) {
+			return false
+		}
+	}
+	for k := range other {
+		if #name(other).has(k) && !m.has(k) {
+			return false
+		}
+	}
+	return true
+}
+`
+
+const entriesTempl = `
+type #name map[#T]#U
+
+func (m #name) entries() []struct{Key #T; Val #U} {
+	es := make([]struct{Key #T; Val #U}, 0, len(m))
+	for k, e := range m {
+		es = append(es, struct{Key #T; Val #U}{k, e})
+	}
+	return es
+}
+`
+
+func entriesGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
-	return genMethod(elemsTempl, "elems_map", mt.Key(), mt.Elem())
+	return genMethod(n, entriesTempl, "entries_map", mt.Key(), mt.Elem())
 }
 
 const filterTempl = `
 type #name []#T
 
 func (xs #name) filter(pred func(#T) bool) []#T {
-	var filtered []#T
+	#alloc(filtered, []#T, upper, len(xs))
 	for _, x := range xs {
 		if pred(x) {
 			filtered = append(filtered, x)
@@ -394,6 +733,20 @@ func (xs #name) filter(pred func(#T) bool) []#T {
 }
 `
 
+const filteriTempl = `
+type #name []#T
+
+func (xs #name) filteri(pred func(int, #T) bool) []#T {
+	#alloc(filtered, []#T, upper, len(xs))
+	for i, x := range xs {
+		if pred(i, x) {
+			filtered = append(filtered, x)
+		}
+	}
+	return filtered
+}
+`
+
 const filterMapTempl = `
 type #name map[#T]#U
 
@@ -411,16 +764,96 @@ func (m #name) filter(pred func(#T, #U) bool) map[#T]#U {
 }
 `
 
-func filterGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func filterGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	switch typ := exprTypes[fn.X].Type.Underlying().(type) {
 	case *types.Slice:
-		return genMethod(filterTempl, "filter_slice", typ.Elem())
+		return genMethod(n, filterTempl, "filter_slice", typ.Elem())
 	case *types.Map:
-		return genMethod(filterMapTempl, "filter_map", typ.Key(), typ.Elem())
+		return genMethod(n, filterMapTempl, "filter_map", typ.Key(), typ.Elem())
 	}
 	return
 }
 
+const findTempl = `
+type #name []#T
+
+func (xs #name) find(pred func(#T) bool) (#T, bool) {
+	for _, x := range xs {
+		if pred(x) {
+			return x, true
+		}
+	}
+	var zero #T
+	return zero, false
+}
+`
+
+const findIndexTempl = `
+type #name []#T
+
+func (xs #name) findIndex(pred func(#T) bool) int {
+	for i, x := range xs {
+		if pred(x) {
+			return i
+		}
+	}
+	return -1
+}
+`
+
+const flatMorphTempl = `
+type #name []#T
+
+func (xs #name) flatMorph(fn func(#T) #U#hint) []#V {
+	#alloc(flatMorphed, []#V, #cap)
+	for _, x := range xs {
+		flatMorphed = append(flatMorphed, fn(x)...)
+	}
+	return flatMorphed
+}
+`
+
+func flatMorphGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	T := sig.Params().At(0).Type()
+	U := sig.Results().At(0).Type()
+	V := U.Underlying().(*types.Slice).Elem()
+	templ := strings.NewReplacer("#hint", "", "#cap", "grow").Replace(flatMorphTempl)
+	if len(args) == 2 {
+		// the second argument is a capacity hint
+		templ = strings.NewReplacer("#hint", ", hint int", "#cap", "exact, hint").Replace(flatMorphTempl)
+	}
+	return genMethod(n, templ, "flatMorph_slice", T, U, V)
+}
+
+const flattenTempl = `
+type #name []#T
+
+func (xss #name) flatten(#hint) []#U {
+	var n int
+	for _, xs := range xss {
+		n += len(xs)
+	}
+	flattened := make([]#U, 0, n)
+	for _, xs := range xss {
+		flattened = append(flattened, xs...)
+	}
+	return flattened
+}
+`
+
+func flattenGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	U := T.Underlying().(*types.Slice).Elem()
+	// the exact length of the result is computed, so a capacity hint is
+	// ignored
+	templ := strings.Replace(flattenTempl, "#hint", "", 1)
+	if len(args) == 1 {
+		templ = strings.Replace(flattenTempl, "#hint", "int", 1)
+	}
+	return genMethod(n, templ, "flatten_slice", T, U)
+}
+
 const foldTempl = `
 type #name []#T
 
@@ -447,19 +880,37 @@ func (xs #name) fold(fn func(#U, #T) #U) #U {
 }
 `
 
-func foldGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func foldGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	// determine arg types
-	sig := exprTypes[args[0]].Type.(*types.Signature)
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
 	T := sig.Params().At(1).Type()
 	U := sig.Params().At(0).Type()
 	if len(args) == 1 {
-		return genMethod(fold1Templ, "fold1_slice", T, U)
+		return genMethod(n, fold1Templ, "fold1_slice", T, U)
 	} else if len(args) == 2 {
-		return genMethod(foldTempl, "fold_slice", T, U)
+		return genMethod(n, foldTempl, "fold_slice", T, U)
 	}
 	return
 }
 
+const foldiTempl = `
+type #name []#T
+
+func (xs #name) foldi(fn func(#U, int, #T) #U, acc #U) #U {
+	for i, x := range xs {
+		acc = fn(acc, i, x)
+	}
+	return acc
+}
+`
+
+func foldiGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	T := sig.Params().At(2).Type()
+	U := sig.Params().At(0).Type()
+	return genMethod(n, foldiTempl, "foldi_slice", T, U)
+}
+
 const foreachTempl = `
 type #name []#T
 
@@ -470,67 +921,486 @@ func (xs #name) foreach(fn func(#T)) {
 }
 `
 
-const keysTempl = `
-type #name map[#T]#U
+const foreachiTempl = `
+type #name []#T
 
-func (m #name) keys() []#T {
-	ks := make([]#T, 0, len(m))
-	for k := range m {
-		ks = append(ks, k)
+func (xs #name) foreachi(fn func(int, #T)) {
+	for i, x := range xs {
+		fn(i, x)
 	}
-	return ks
 }
 `
 
-func keysGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
-	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
-	return genMethod(keysTempl, "keys_map", mt.Key(), mt.Elem())
-}
-
-const morphTempl = `
+const groupByTempl = `
 type #name []#T
 
-func (xs #name) morph(fn func(#T) #U) []#U {
-	morphed := make([]#U, len(xs))
-	for i := range xs {
-		morphed[i] = fn(xs[i])
+func (xs #name) groupBy(key func(#T) #U) map[#U][]#T {
+	groups := make(map[#U][]#T)
+	for _, x := range xs {
+		k := key(x)
+		groups[k] = append(groups[k], x)
 	}
-	return morphed
+	return groups
 }
 `
 
-const morphMapTempl = `
-type #name map[#T]#U
+func groupByGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	// determine arg types
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	U := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+	return genMethod(n, groupByTempl, "groupBy_slice", T, U)
+}
 
-func (m #name) morph(fn func(#T, #U) (#V, #W)) map[#V]#W {
-	if m == nil {
-		return nil
-	}
-	morphed := make(map[#V]#W, len(m))
-	for k, e := range m {
-		mk, me := fn(k, e)
-		morphed[mk] = me
+const indexOfTempl = `
+type #name []#T
+
+func (xs #name) indexOf(e #T) int {
+	for i, x := range xs {
+		if x == e {
+			return i
+		}
 	}
-	return morphed
+	return -1
 }
 `
 
-func morphGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
-	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
-	switch exprTypes[fn.X].Type.Underlying().(type) {
+const indexOfNilTempl = `
+type #name []#T
+
+func (xs #name) indexOf(_ #T) int {
+	for i, x := range xs {
+		if x == nil {
+			return i
+		}
+	}
+	return -1
+}
+`
+
+func indexOfGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	if !types.Comparable(T) {
+		// if type is not comparable, then the argument must be nil
+		// (otherwise type-check would have failed)
+		return genMethod(n, indexOfNilTempl, "indexOf_slice_nil", T)
+	}
+	return genMethod(n, indexOfTempl, "indexOf_slice", T)
+}
+
+const intersectTempl = `
+type #name []#T
+
+func (xs #name) intersect(ys []#T) []#T {
+	include := make(map[#T]struct{}, len(ys))
+	for _, y := range ys {
+		include[y] = struct{}{}
+	}
+	#alloc(inter, []#T, upper, len(xs))
+	for _, x := range xs {
+		if _, ok := include[x]; ok {
+			inter = append(inter, x)
+			delete(include, x)
+		}
+	}
+	return inter
+}
+`
+
+const intersectMapTempl = `
+type #name map[#T]#U
+
+func (m #name) intersect(other map[#T]#U) map[#T]#U {
+	inter := make(map[#T]#U)
+	for k := range m {
+		if m.has(k) && #name(other).has(k) {
+			inter[k] = #member
+		}
+	}
+	return inter
+}
+`
+
+const invertTempl = `
+type #name map[#T]#U
+
+func (m #name) invert() map[#U]#T {
+	if m == nil {
+		return nil
+	}
+	inverted := make(map[#U]#T, len(m))
+	for k, e := range m {
+		inverted[e] = k
+	}
+	return inverted
+}
+`
+
+func invertGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	return genMethod(n, invertTempl, "invert_map", mt.Key(), mt.Elem())
+}
+
+const isSubsetTempl = `
+type #name []#T
+
+func (xs #name) isSubset(ys []#T) bool {
+	yset := make(map[#T]struct{}, len(ys))
+	for _, y := range ys {
+		yset[y] = struct{}{}
+	}
+	for _, x := range xs {
+		if _, ok := yset[x]; !ok {
+			return false
+		}
+	}
+	return true
+}
+`
+
+const isSubsetMapTempl = `
+type #name map[#T]#U
+
+func (m #name) isSubset(other map[#T]#U) bool {
+	for k := range m {
+		if m.has(k) && !#name(other).has(k) {
+			return false
+		}
+	}
+	return true
+}
+`
+
+const isSupersetTempl = `
+type #name []#T
+
+func (xs #name) isSuperset(ys []#T) bool {
+	xset := make(map[#T]struct{}, len(xs))
+	for _, x := range xs {
+		xset[x] = struct{}{}
+	}
+	for _, y := range ys {
+		if _, ok := xset[y]; !ok {
+			return false
+		}
+	}
+	return true
+}
+`
+
+const isSupersetMapTempl = `
+type #name map[#T]#U
+
+func (m #name) isSuperset(other map[#T]#U) bool {
+	for k := range other {
+		if #name(other).has(k) && !m.has(k) {
+			return false
+		}
+	}
+	return true
+}
+`
+
+const keysTempl = `
+type #name map[#T]#U
+
+func (m #name) keys() []#T {
+	ks := make([]#T, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+`
+
+func keysGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	return genMethod(n, keysTempl, "keys_map", mt.Key(), mt.Elem())
+}
+
+const lastIndexOfTempl = `
+type #name []#T
+
+func (xs #name) lastIndexOf(e #T) int {
+	for i := len(xs) - 1; i >= 0; i-- {
+		if xs[i] == e {
+			return i
+		}
+	}
+	return -1
+}
+`
+
+const lastIndexOfNilTempl = `
+type #name []#T
+
+func (xs #name) lastIndexOf(_ #T) int {
+	for i := len(xs) - 1; i >= 0; i-- {
+		if xs[i] == nil {
+			return i
+		}
+	}
+	return -1
+}
+`
+
+func lastIndexOfGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	if !types.Comparable(T) {
+		// if type is not comparable, then the argument must be nil
+		// (otherwise type-check would have failed)
+		return genMethod(n, lastIndexOfNilTempl, "lastIndexOf_slice_nil", T)
+	}
+	return genMethod(n, lastIndexOfTempl, "lastIndexOf_slice", T)
+}
+
+const mapKeysTempl = `
+type #name map[#T]#U
+
+func (m #name) mapKeys(fn func(#T) #V) map[#V]#U {
+	if m == nil {
+		return nil
+	}
+	mapped := make(map[#V]#U, len(m))
+	for k, e := range m {
+		mapped[fn(k)] = e
+	}
+	return mapped
+}
+`
+
+func mapKeysGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	V := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+	return genMethod(n, mapKeysTempl, "mapKeys_map", mt.Key(), mt.Elem(), V)
+}
+
+const mapValuesTempl = `
+type #name map[#T]#U
+
+func (m #name) mapValues(fn func(#U) #V) map[#T]#V {
+	if m == nil {
+		return nil
+	}
+	mapped := make(map[#T]#V, len(m))
+	for k, e := range m {
+		mapped[k] = fn(e)
+	}
+	return mapped
+}
+`
+
+func mapValuesGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	V := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+	return genMethod(n, mapValuesTempl, "mapValues_map", mt.Key(), mt.Elem(), V)
+}
+
+const maxSliceTempl = `
+type #name []#T
+
+func (xs #name) max() (#T, bool) {
+	if len(xs) == 0 {
+		var zero #T
+		return zero, false
+	}
+	max := xs[0]
+	for _, x := range xs[1:] {
+		if max < x || (max != max && x == x) {
+			max = x
+		}
+	}
+	return max, true
+}
+`
+
+const maxByTempl = `
+type #name []#T
+
+func (xs #name) maxBy(key func(#T) #U) (#T, bool) {
+	if len(xs) == 0 {
+		var zero #T
+		return zero, false
+	}
+	max, maxKey := xs[0], key(xs[0])
+	for _, x := range xs[1:] {
+		if k := key(x); maxKey < k || (maxKey != maxKey && k == k) {
+			max, maxKey = x, k
+		}
+	}
+	return max, true
+}
+`
+
+func maxByGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	T := sig.Params().At(0).Type()
+	U := sig.Results().At(0).Type()
+	return genMethod(n, maxByTempl, "maxBy_slice", T, U)
+}
+
+const meanTempl = `
+type #name []#T
+
+func (xs #name) mean() (float64, bool) {
+	if len(xs) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += float64(x)
+	}
+	return sum / float64(len(xs)), true
+}
+`
+
+const minSliceTempl = `
+type #name []#T
+
+func (xs #name) min() (#T, bool) {
+	if len(xs) == 0 {
+		var zero #T
+		return zero, false
+	}
+	min := xs[0]
+	for _, x := range xs[1:] {
+		if x < min || (x != x && min == min) {
+			min = x
+		}
+	}
+	return min, true
+}
+`
+
+const minByTempl = `
+type #name []#T
+
+func (xs #name) minBy(key func(#T) #U) (#T, bool) {
+	if len(xs) == 0 {
+		var zero #T
+		return zero, false
+	}
+	min, minKey := xs[0], key(xs[0])
+	for _, x := range xs[1:] {
+		if k := key(x); k < minKey || (k != k && minKey == minKey) {
+			min, minKey = x, k
+		}
+	}
+	return min, true
+}
+`
+
+func minByGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	T := sig.Params().At(0).Type()
+	U := sig.Results().At(0).Type()
+	return genMethod(n, minByTempl, "minBy_slice", T, U)
+}
+
+const morphTempl = `
+type #name []#T
+
+func (xs #name) morph(fn func(#T) #U) []#U {
+	morphed := make([]#U, len(xs))
+	for i := range xs {
+		morphed[i] = fn(xs[i])
+	}
+	return morphed
+}
+`
+
+const morphMapTempl = `
+type #name map[#T]#U
+
+func (m #name) morph(fn func(#T, #U) (#V, #W)) map[#V]#W {
+	if m == nil {
+		return nil
+	}
+	morphed := make(map[#V]#W, len(m))
+	for k, e := range m {
+		mk, me := fn(k, e)
+		morphed[mk] = me
+	}
+	return morphed
+}
+`
+
+func morphGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	switch exprTypes[fn.X].Type.Underlying().(type) {
 	case *types.Slice:
 		T := sig.Params().At(0).Type()
 		U := sig.Results().At(0).Type()
-		return genMethod(morphTempl, "morph_slice", T, U)
+		return genMethod(n, morphTempl, "morph_slice", T, U)
 	case *types.Map:
 		T := sig.Params().At(0).Type()
 		U := sig.Params().At(1).Type()
 		V := sig.Results().At(0).Type()
 		W := sig.Results().At(1).Type()
-		return genMethod(morphMapTempl, "morph_map", T, U, V, W)
+		return genMethod(n, morphMapTempl, "morph_map", T, U, V, W)
+	}
+	return
+}
+
+const morphiTempl = `
+type #name []#T
+
+func (xs #name) morphi(fn func(int, #T) #U) []#U {
+	morphed := make([]#U, len(xs))
+	for i, x := range xs {
+		morphed[i] = fn(i, x)
+	}
+	return morphed
+}
+`
+
+func morphiGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+	T := sig.Params().At(1).Type()
+	U := sig.Results().At(0).Type()
+	return genMethod(n, morphiTempl, "morphi_slice", T, U)
+}
+
+const pairsTempl = `
+type #name []#T
+
+func (xs #name) pairs() [][2]#T {
+	if len(xs) < 2 {
+		return nil
+	}
+	pairs := make([][2]#T, len(xs)-1)
+	for i := range pairs {
+		pairs[i] = [2]#T{xs[i], xs[i+1]}
+	}
+	return pairs
+}
+`
+
+const partitionTempl = `
+type #name []#T
+
+func (xs #name) partition(pred func(#T) bool) (yes, no []#T) {
+	for _, x := range xs {
+		if pred(x) {
+			yes = append(yes, x)
+		} else {
+			no = append(no, x)
+		}
 	}
 	return
 }
+`
+
+const productTempl = `
+type #name []#T
+
+func (xs #name) product() #T {
+	product := #T(1)
+	for _, x := range xs {
+		product *= x
+	}
+	return product
+}
+`
 
 const reverseTempl = `
 type #name []#T
@@ -543,51 +1413,66 @@ func (xs #name) reverse() []#T {
 	return reversed
 }
 `
-const sortTempl = `
-type #name []#T
 
-func (xs #name) Len() int           { return len(xs) }
-func (xs #name) Swap(i, j int)      { xs[i], xs[j] = xs[j], xs[i] }
-func (xs #name) Less(i, j int) bool { return xs[i] < xs[j] }
+const sumTempl = `
+type #name []#T
 
-func (xs #name) sort() []#T {
-	s := make([]#T, len(xs))
-	copy(s, xs)
-	sort.Sort(#name(s))
-	return s
+func (xs #name) sum() #T {
+	var sum #T
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
 }
 `
 
-const sortByTempl = `
+const symmetricDifferenceTempl = `
 type #name []#T
 
-type #namesorter struct {
-	data []#T
-	less func(#T, #T) bool
-}
-
-func (xs #namesorter) Len() int { return len(xs.data) }
-func (xs #namesorter) Swap(i, j int) { xs.data[i], xs.data[j] = xs.data[j], xs.data[i] }
-func (xs #namesorter) Less(i, j int) bool { return xs.less(xs.data[i], xs.data[j]) }
-
-func (xs #name) sort(less func(#T, #T) bool) []#T {
-	s := #namesorter{make([]#T, len(xs)), less}
-	copy(s.data, xs)
-	sort.Sort(s)
-	return s.data
+func (xs #name) symmetricDifference(ys []#T) []#T {
+	xset := make(map[#T]struct{}, len(xs))
+	for _, x := range xs {
+		xset[x] = struct{}{}
+	}
+	yset := make(map[#T]struct{}, len(ys))
+	for _, y := range ys {
+		yset[y] = struct{}{}
+	}
+	#alloc(diff, []#T, upper, len(xs)+len(ys))
+	for _, x := range xs {
+		if _, ok := yset[x]; !ok {
+			diff = append(diff, x)
+			yset[x] = struct{}{}
+		}
+	}
+	for _, y := range ys {
+		if _, ok := xset[y]; !ok {
+			diff = append(diff, y)
+			xset[y] = struct{}{}
+		}
+	}
+	return diff
 }
 `
 
-func sortGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
-	// determine arg types
-	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
-	if len(args) == 0 {
-		return genMethod(sortTempl, "sort_slice", T)
-	} else if len(args) == 1 {
-		return genMethod(sortByTempl, "sortBy_slice", T)
+const symmetricDifferenceMapTempl = `
+type #name map[#T]#U
+
+func (m #name) symmetricDifference(other map[#T]#U) map[#T]#U {
+	diff := make(map[#T]#U)
+	for k := range m {
+		if m.has(k) && !#name(other).has(k) {
+			diff[k] = #member
+		}
 	}
-	return
+	for k := range other {
+		if #name(other).has(k) && !m.has(k) {
+			diff[k] = #member
+		}
+	}
+	return diff
 }
+`
 
 const takeTempl = `
 type #name []#T
@@ -637,12 +1522,12 @@ func (xs #name) toMap(fn func(#T) #U) map[#T]#U {
 }
 `
 
-func toMapGen(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+func toMapGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
 	// determine arg type
-	sig := exprTypes[args[0]].Type.(*types.Signature)
+	sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
 	T := sig.Params().At(0).Type()
 	U := sig.Results().At(0).Type()
-	return genMethod(toMapTempl, "toMap_slice", T, U)
+	return genMethod(n, toMapTempl, "toMap_slice", T, U)
 }
 
 const toSetTempl = `
@@ -657,12 +1542,53 @@ func (xs #name) toSet() map[#T]struct{} {
 }
 `
 
+const unionTempl = `
+type #name []#T
+
+func (xs #name) union(ys []#T) []#T {
+	seen := make(map[#T]struct{}, len(xs))
+	#alloc(u, []#T, upper, len(xs)+len(ys))
+	for _, x := range xs {
+		if _, ok := seen[x]; !ok {
+			u = append(u, x)
+			seen[x] = struct{}{}
+		}
+	}
+	for _, y := range ys {
+		if _, ok := seen[y]; !ok {
+			u = append(u, y)
+			seen[y] = struct{}{}
+		}
+	}
+	return u
+}
+`
+
+const unionMapTempl = `
+type #name map[#T]#U
+
+func (m #name) union(other map[#T]#U) map[#T]#U {
+	u := make(map[#T]#U, len(m))
+	for k := range m {
+		if m.has(k) {
+			u[k] = #member
+		}
+	}
+	for k := range other {
+		if #name(other).has(k) {
+			u[k] = #member
+		}
+	}
+	return u
+}
+`
+
 const uniqTempl = `
 type #name []#T
 
 func (xs #name) uniq() []#T {
 	set := make(map[#T]struct{})
-	var unique []#T
+	#alloc(unique, []#T, upper, len(xs))
 	for _, x := range xs {
 		if _, ok := set[x]; !ok {
 			unique = append(unique, x)
@@ -672,3 +1598,21 @@ func (xs #name) uniq() []#T {
 	return unique
 }
 `
+
+const windowTempl = `
+type #name []#T
+
+func (xs #name) window(n int) [][]#T {
+	if n <= 0 {
+		panic("window: size must be positive")
+	}
+	if len(xs) < n {
+		return nil
+	}
+	windows := make([][]#T, len(xs)-n+1)
+	for i := range windows {
+		windows[i] = xs[i : i+n : i+n]
+	}
+	return windows
+}
+`
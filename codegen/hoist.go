@@ -0,0 +1,136 @@
+package codegen
+
+// Function hoisting
+//
+// not and compose return functions. Their generic implementations return a
+// closure that wraps their arguments, but when every argument is a
+// package-level function, the call is instead replaced with a new top-level
+// function that calls the arguments directly. For example:
+//
+//    func even(i int) bool { return i%2 == 0 }
+//
+//    odd := not(even)
+//
+// compiles to:
+//
+//    func __plyfn_1_not_even(a0 int) bool {
+//    	return !even(a0)
+//    }
+//
+//    odd := __plyfn_1_not_even
+//
+// The new function does not allocate, and since it calls even directly, the
+// Go compiler may inline even into it. Local functions are not hoisted, since
+// the new function cannot refer to them.
+
+import (
+	"go/ast"
+	"strings"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// hoistGenerators generate a top-level function for a hoisted call, given the
+// expressions and signatures of its function arguments.
+var hoistGenerators = map[string]func(n *namer, fns []string, sigs []*types.Signature) (name, code string){
+	"compose": composeHoistGen,
+	"not":     notHoistGen,
+}
+
+const notHoistTempl = `
+func #name(#params) #T {
+	return !#f(#args)
+}
+`
+
+func notHoistGen(n *namer, fns []string, sigs []*types.Signature) (name, code string) {
+	name = n.fnName("not_" + baseName(fns[0]))
+	code = specify(notHoistTempl, name, sigs[0].Results().At(0).Type())
+	code = strings.Replace(code, "#f", fns[0], -1)
+	code = replaceParams(code, sigs[0])
+	return
+}
+
+const composeHoistTempl = `
+func #name(#params) #T {
+	return #f(#g(#args))
+}
+`
+
+func composeHoistGen(n *namer, fns []string, sigs []*types.Signature) (name, code string) {
+	name = n.fnName("compose_" + baseName(fns[0]) + "_" + baseName(fns[1]))
+	code = specify(composeHoistTempl, name, sigs[0].Results().At(0).Type())
+	code = strings.Replace(code, "#f", fns[0], -1)
+	code = strings.Replace(code, "#g", fns[1], -1)
+	code = replaceParams(code, sigs[1])
+	return
+}
+
+// baseName strips the package qualifier, if any, from a function name.
+func baseName(fn string) string {
+	return fn[strings.LastIndexByte(fn, '.')+1:]
+}
+
+// hoist replaces call with a top-level function, if call is a builtin whose
+// function arguments are all package-level functions. If call cannot be
+// hoisted, hoist returns nil.
+func (s specializer) hoist(call *ast.CallExpr) ast.Expr {
+	fn, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	gen, ok := hoistGenerators[fn.Name]
+	if !ok || !isPlyFunc(fn, s.uses) {
+		return nil
+	}
+	fns := make([]string, len(call.Args))
+	sigs := make([]*types.Signature, len(call.Args))
+	for i, arg := range call.Args {
+		f := s.packageFunc(arg)
+		if f == nil {
+			return nil
+		}
+		fns[i] = types.ExprString(unparen(arg))
+		sigs[i] = f.Type().(*types.Signature)
+	}
+
+	// identical calls share a single function
+	key := fn.Name + "(" + strings.Join(fns, ", ") + ")"
+	name, ok := s.hoisted[key]
+	if !ok {
+		var code string
+		name, code = gen(s.names, fns, sigs)
+		if err := s.addDecl(name, code); err != nil {
+			s.genError(call, name, err)
+			return nil
+		}
+		s.hoisted[key] = name
+	}
+	return ast.NewIdent(name)
+}
+
+// packageFunc returns the package-level function denoted by e, which must be
+// an identifier or a qualified identifier. Otherwise, it returns nil.
+func (s specializer) packageFunc(e ast.Expr) *types.Func {
+	var id *ast.Ident
+	switch e := unparen(e).(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		x, ok := e.X.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		if _, ok := s.uses[x].(*types.PkgName); !ok {
+			return nil
+		}
+		id = e.Sel
+	default:
+		return nil
+	}
+	f, ok := s.uses[id].(*types.Func)
+	if !ok || f.Pkg() == nil || f.Parent() != f.Pkg().Scope() {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,55 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestHoist checks that calls to not and compose are replaced with top-level
+// functions when their arguments are package-level functions, and left alone
+// otherwise.
+func TestHoist(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string // empty if the call should not be hoisted
+	}{
+		{`not(even)`, `_ = __plyfn_1_not_even`},
+		{`not((even))`, `_ = __plyfn_1_not_even`},
+		{`compose(even, double)`, `_ = __plyfn_1_compose_even_double`},
+		{`compose(double, sum)(1, 2)`, `_ = __plyfn_1_compose_double_sum(1, 2)`},
+
+		// not hoisted
+		{`not(local)`, ``},
+		{`not(f)`, ``},
+		{`not(func(int) bool { return true })`, ``},
+		{`compose(even, local2)`, ``},
+	}
+
+	decls := `
+func even(i int) bool { return i%2 == 0 }
+
+func double(x int) int { return x * 2 }
+
+func sum(xs ...int) int { return len(xs) }
+
+var f = even
+`
+	for _, test := range tests {
+		code, err := compileMain(nil, decls, `
+	local := func(i int) bool { return true }
+	local2 := func(i int) int { return i }
+	_, _ = local, local2
+	_ = `+test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if test.exp == "" {
+			if !strings.Contains(code, "_ = __plyfn_1_"+test.expr[:strings.IndexByte(test.expr, '(')]+"(") {
+				t.Errorf("%s: expected call to be left alone, got:\n%s", test.expr, code)
+			}
+		} else if !strings.Contains(code, test.exp+"\n") {
+			t.Errorf("%s: expected %s, got:\n%s", test.expr, test.exp, code)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package codegen
+
+// Callback inlining
+//
+// Pipelines receive their callbacks as function values, and call them once
+// for each value:
+//
+//    xs.filter(func(x int) bool { return x > 3 }).morph(square)
+//
+// would otherwise compile to a loop containing:
+//
+//    if !__plyarg_0(e1) {
+//        continue
+//    }
+//    e2 := __plyarg_1(e1)
+//
+// When a callback is a function literal whose body is a single return
+// statement, its body is spliced into the pipeline instead, with each
+// parameter renamed to the corresponding argument of the call:
+//
+//    if !(e1 > 3) {
+//        continue
+//    }
+//    e2 := __plyarg_1(e1)
+//
+// The literal may refer to package-level identifiers, and may capture local
+// variables that are never modified after their declaration. Since the
+// pipeline is declared at package level, captured variables are passed to it
+// as additional arguments, under their original names.
+//
+// A callback is left alone if its body contains another ply call or function
+// literal, if it refers to any other local identifier, if the pipeline uses
+// it other than by calling it with variables as arguments, or if splicing it
+// would cause one of its identifiers to be shadowed by a pipeline variable.
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// A callback is a function literal whose body can be spliced into a
+// pipeline.
+type callback struct {
+	fset    *token.FileSet
+	nparams int
+	body    ast.Expr
+	conv    ast.Expr // result type, if body must be converted to it
+	hasLit  bool     // body contains a composite literal
+	// refs are the references to the parameters of the literal in body.
+	refs []paramRef
+	// free are the identifiers referenced by body that must not be shadowed
+	// by pipeline variables, including captured variables.
+	free map[string]types.Object
+	// captures are the local variables referenced by body, in order of
+	// appearance.
+	captures []*types.Var
+}
+
+// A paramRef is a reference to the i'th parameter of a function literal.
+type paramRef struct {
+	id *ast.Ident
+	i  int
+}
+
+// mutatedVars returns the variables in f that may be modified after their
+// declaration: those that are assigned to, incremented, or have their address
+// taken, whether explicitly or by calling a pointer method or slicing an
+// array.
+func mutatedVars(f *ast.File, info *types.Info) map[types.Object]bool {
+	mutated := make(map[types.Object]bool)
+	// mark marks the variable at the root of e, e.g. x in x.f[i]
+	mark := func(e ast.Expr) {
+		for {
+			switch x := e.(type) {
+			case *ast.ParenExpr:
+				e = x.X
+			case *ast.SelectorExpr:
+				e = x.X
+			case *ast.IndexExpr:
+				e = x.X
+			case *ast.StarExpr:
+				e = x.X
+			case *ast.Ident:
+				if obj := info.Uses[x]; obj != nil {
+					mutated[obj] = true
+				}
+				return
+			default:
+				return
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				mark(lhs)
+			}
+		case *ast.IncDecStmt:
+			mark(n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				mark(n.Key)
+				if n.Value != nil {
+					mark(n.Value)
+				}
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				mark(n.X)
+			}
+		case *ast.SliceExpr:
+			if _, ok := info.Types[n.X].Type.Underlying().(*types.Array); ok {
+				mark(n.X)
+			}
+		case *ast.SelectorExpr:
+			// ply methods have no receiver
+			if sel, ok := info.Selections[n]; ok && sel.Kind() == types.MethodVal && sel.Obj().Type().(*types.Signature).Recv() != nil {
+				_, ptrRecv := sel.Obj().Type().(*types.Signature).Recv().Type().(*types.Pointer)
+				_, ptrX := sel.Recv().Underlying().(*types.Pointer)
+				if ptrRecv && !ptrX {
+					mark(n.X)
+				}
+			}
+		}
+		return true
+	})
+	return mutated
+}
+
+// callback returns the callback for lit, or nil if lit cannot be inlined.
+func (s specializer) callback(lit *ast.FuncLit) *callback {
+	if len(lit.Body.List) != 1 {
+		return nil
+	}
+	ret, ok := lit.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil
+	}
+	sig := s.types[lit].Type.(*types.Signature)
+	if sig.Results().Len() != 1 {
+		return nil
+	}
+	cb := &callback{
+		fset:    s.fset,
+		nparams: sig.Params().Len(),
+		body:    ret.Results[0],
+		free:    make(map[string]types.Object),
+	}
+
+	// the body must be converted to the result type if its type differs,
+	// or if it is a constant whose default type differs
+	res := sig.Results().At(0).Type()
+	if tv := s.types[cb.body]; !types.Identical(tv.Type, res) || (tv.Value != nil && !types.Identical(s.constType(cb.body), res)) {
+		cb.conv = lit.Type.Results.List[0].Type
+	}
+
+	// locate each parameter
+	params := make(map[types.Object]int)
+	for i := 0; i < sig.Params().Len(); i++ {
+		params[sig.Params().At(i)] = i
+	}
+
+	ok = true
+	var check func(n ast.Node) bool
+	check = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			ok = false
+		case *ast.CompositeLit:
+			cb.hasLit = true
+		case *ast.SelectorExpr:
+			// the selector itself is a field, method, or qualified
+			// identifier, none of which can be shadowed
+			ast.Inspect(n.X, check)
+			return false
+		case *ast.CallExpr:
+			switch fn := unparen(n.Fun).(type) {
+			case *ast.Ident:
+				if isPlyFunc(fn, s.uses) {
+					ok = false
+				}
+			case *ast.SelectorExpr:
+				if isPlyMethod(fn, s.selections) {
+					ok = false
+				}
+			}
+		case *ast.Ident:
+			obj := s.uses[n]
+			if obj == nil {
+				break
+			}
+			if i, isParam := params[obj]; isParam {
+				if s.mutated[obj] {
+					ok = false
+				}
+				cb.refs = append(cb.refs, paramRef{n, i})
+				break
+			}
+			if v, isVar := obj.(*types.Var); isVar && v.IsField() {
+				return true // key of a struct literal
+			}
+			if obj.Parent() == types.Universe {
+				return true
+			}
+			if _, isPkg := obj.(*types.PkgName); !isPkg && (obj.Pkg() == nil || obj.Parent() != obj.Pkg().Scope()) {
+				// local identifier; must be an unmodified variable
+				v, isVar := obj.(*types.Var)
+				if !isVar || s.mutated[v] || isLocalType(v.Type()) {
+					ok = false
+					break
+				}
+				if _, seen := cb.free[v.Name()]; !seen {
+					cb.captures = append(cb.captures, v)
+				}
+			}
+			if other, seen := cb.free[obj.Name()]; seen && other != obj {
+				ok = false
+			}
+			cb.free[obj.Name()] = obj
+		}
+		return ok
+	}
+	ast.Inspect(cb.body, check)
+	if cb.conv != nil {
+		ast.Inspect(cb.conv, check)
+	}
+	if !ok {
+		return nil
+	}
+	return cb
+}
+
+// isLocalType reports whether t is a named type declared inside a function.
+func isLocalType(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Parent() != named.Obj().Pkg().Scope()
+}
+
+// constType returns the type of the constant expression e, if it were
+// declared with :=. It returns nil if the type cannot be determined.
+func (s specializer) constType(e ast.Expr) types.Type {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return types.Typ[types.Int]
+		case token.FLOAT:
+			return types.Typ[types.Float64]
+		case token.IMAG:
+			return types.Typ[types.Complex128]
+		case token.CHAR:
+			return types.Typ[types.Rune]
+		case token.STRING:
+			return types.Typ[types.String]
+		}
+	case *ast.Ident:
+		if c, ok := s.uses[e].(*types.Const); ok {
+			return defaultType(c.Type())
+		}
+	case *ast.ParenExpr:
+		return s.constType(e.X)
+	case *ast.UnaryExpr:
+		return s.constType(e.X)
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return types.Typ[types.Bool]
+		case token.SHL, token.SHR:
+			return s.constType(e.X)
+		}
+		if x, y := s.constType(e.X), s.constType(e.Y); x != nil && y != nil && types.Identical(x, y) {
+			return x
+		}
+	}
+	return nil
+}
+
+// defaultType returns the default type of an untyped type, and t itself
+// otherwise.
+func defaultType(t types.Type) types.Type {
+	if b, ok := t.(*types.Basic); ok && b.Info()&types.IsUntyped != 0 {
+		switch b.Kind() {
+		case types.UntypedBool:
+			return types.Typ[types.Bool]
+		case types.UntypedInt:
+			return types.Typ[types.Int]
+		case types.UntypedRune:
+			return types.Typ[types.Rune]
+		case types.UntypedFloat:
+			return types.Typ[types.Float64]
+		case types.UntypedComplex:
+			return types.Typ[types.Complex128]
+		case types.UntypedString:
+			return types.Typ[types.String]
+		}
+	}
+	return t
+}
+
+// expand returns the body of cb, with its parameters renamed to args.
+func (cb *callback) expand(args []string) string {
+	for _, ref := range cb.refs {
+		name := ref.id.Name
+		ref.id.Name = args[ref.i]
+		defer func(id *ast.Ident) { id.Name = name }(ref.id)
+	}
+	var buf bytes.Buffer
+	if cb.conv != nil {
+		printer.Fprint(&buf, cb.fset, cb.conv)
+		buf.WriteByte('(')
+		printer.Fprint(&buf, cb.fset, cb.body)
+		buf.WriteByte(')')
+		return buf.String()
+	}
+	printer.Fprint(&buf, cb.fset, cb.body)
+	return buf.String()
+}
+
+// primary reports whether the expansion of cb is a primary expression, and
+// thus never needs to be parenthesized.
+func (cb *callback) primary() bool {
+	if cb.conv != nil {
+		return true
+	}
+	switch unparen(cb.body).(type) {
+	case *ast.UnaryExpr, *ast.BinaryExpr, *ast.StarExpr:
+		return false
+	}
+	return true
+}
+
+var argListRegexp = regexp.MustCompile(`^\(\s*(\w+(\s*,\s*\w+)*)?\s*\)`)
+
+// splice replaces each call to the parameter name in code with the expansion
+// of cb. It reports false, leaving code unmodified, if name is used other than
+// by calling it with variables as arguments.
+func (cb *callback) splice(code, name string) (string, bool) {
+	used := make([]bool, cb.nparams)
+	for _, ref := range cb.refs {
+		used[ref.i] = true
+	}
+	var dropped []string // arguments of unused parameters
+
+	re := regexp.MustCompile(`\b` + name + `\b`)
+	var buf bytes.Buffer
+	prev := 0
+	for _, loc := range re.FindAllStringIndex(code, -1) {
+		argList := argListRegexp.FindString(code[loc[1]:])
+		if argList == "" {
+			return code, false
+		}
+		var args []string
+		for _, arg := range strings.Split(strings.Trim(argList, "()"), ",") {
+			if arg = strings.TrimSpace(arg); arg != "" {
+				args = append(args, arg)
+			}
+		}
+		if len(args) != cb.nparams {
+			return code, false
+		}
+		for i, arg := range args {
+			if !used[i] {
+				dropped = append(dropped, arg)
+			}
+		}
+		end := loc[1] + len(argList)
+		exp := cb.expand(args)
+		if (!cb.primary() && !isCompleteExpr(code[:loc[0]], code[end:])) || (cb.hasLit && inHeader(code[:loc[0]])) {
+			exp = "(" + exp + ")"
+		}
+		buf.WriteString(code[prev:loc[0]])
+		buf.WriteString(exp)
+		prev = end
+	}
+	buf.WriteString(code[prev:])
+
+	// an argument of an unused parameter must still be used elsewhere, or its
+	// declaration will be the only remaining reference to it
+	for _, arg := range dropped {
+		if len(regexp.MustCompile(`\b`+arg+`\b`).FindAllStringIndex(buf.String(), 2)) < 2 {
+			return code, false
+		}
+	}
+	return buf.String(), true
+}
+
+// isCompleteExpr reports whether an expression between before and after is
+// a complete expression, rather than an operand of an operator.
+func isCompleteExpr(before, after string) bool {
+	before = strings.TrimRight(before, " \t")
+	after = strings.TrimLeft(after, " \t")
+	okBefore := false
+	switch {
+	case strings.HasSuffix(before, ":="):
+		okBefore = true
+	case strings.HasSuffix(before, "="):
+		// exclude comparisons
+		b := strings.TrimSuffix(before, "=")
+		okBefore = b == "" || !strings.ContainsAny(b[len(b)-1:], "=!<>")
+	case strings.HasSuffix(before, "("), strings.HasSuffix(before, ","), strings.HasSuffix(before, "["),
+		strings.HasSuffix(before, ";"), strings.HasSuffix(before, "\n"),
+		strings.HasSuffix(before, "\tif"), strings.HasSuffix(before, "\treturn"):
+		okBefore = true
+	}
+	okAfter := after == "" || strings.ContainsAny(after[:1], "),]{;\n")
+	return okBefore && okAfter
+}
+
+// inHeader reports whether the text following before is in the header of an
+// if, for, or switch statement, where a composite literal must be
+// parenthesized.
+func inHeader(before string) bool {
+	line := strings.TrimLeft(before[strings.LastIndexByte(before, '\n')+1:], " \t")
+	return strings.HasPrefix(line, "if ") || strings.HasPrefix(line, "for ") || strings.HasPrefix(line, "switch ")
+}
+
+// declaredNames returns the set of identifiers declared in the function
+// body code, including the named results in ret.
+func declaredNames(ret, code string) map[string]bool {
+	names := make(map[string]bool)
+	// an #alloc directive declares the same name under any policy
+	code = expandAlloc(code, AllocGrow)
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p; func _() "+ret+" {"+code+"}", 0)
+	if err != nil {
+		return nil
+	}
+	fn := f.Decls[0].(*ast.FuncDecl)
+	if fn.Type.Results != nil {
+		for _, field := range fn.Type.Results.List {
+			for _, id := range field.Names {
+				names[id.Name] = true
+			}
+		}
+	}
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		// the parser resolves each identifier declared in the body
+		if id, ok := n.(*ast.Ident); ok && id.Obj != nil && id.Obj.Kind != ast.Lbl {
+			names[id.Name] = true
+		}
+		return true
+	})
+	return names
+}
+
+// findCallbacks records the arguments of p that are function literals that
+// can be inlined.
+func (s specializer) findCallbacks(p *pipeline) {
+	i := 0
+	for _, fn := range p.fns {
+		for _, arg := range fn.Args {
+			if lit, ok := unparen(arg).(*ast.FuncLit); ok {
+				if cb := s.callback(lit); cb != nil {
+					if p.callbacks == nil {
+						p.callbacks = make(map[int]*callback)
+					}
+					p.callbacks[i] = cb
+				}
+			}
+			i++
+		}
+	}
+}
+
+// inline splices the callbacks of p into body, returning the new body, the
+// indices of the inlined arguments, and the variables captured by them.
+func (p *pipeline) inline(body string) (string, map[int]bool, []*types.Var) {
+	inlined := make(map[int]bool)
+	var captures []*types.Var
+	if len(p.callbacks) == 0 {
+		return body, inlined, captures
+	}
+	// identifiers already in use, including the named results of the
+	// pipeline, must not be referenced by a callback, lest they be shadowed
+	declared := declaredNames(p.ts[len(p.ts)-1].ret, body)
+	if declared == nil {
+		return body, inlined, captures
+	}
+	declared["recv"] = true
+	free := make(map[string]types.Object)
+
+	indices := make([]int, 0, len(p.callbacks))
+	for i := range p.callbacks {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+outer:
+	for _, i := range indices {
+		cb := p.callbacks[i]
+		for name, obj := range cb.free {
+			if declared[name] {
+				continue outer
+			} else if other, ok := free[name]; ok && other != obj {
+				continue outer
+			}
+		}
+		code, ok := cb.splice(body, "__plyarg_"+strconv.Itoa(i))
+		if !ok {
+			continue
+		}
+		body = code
+		inlined[i] = true
+		for name, obj := range cb.free {
+			free[name] = obj
+		}
+		for _, v := range cb.captures {
+			if !containsVar(captures, v) {
+				captures = append(captures, v)
+			}
+		}
+	}
+	return body, inlined, captures
+}
+
+func containsVar(vs []*types.Var, v *types.Var) bool {
+	for _, w := range vs {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
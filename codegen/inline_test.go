@@ -0,0 +1,62 @@
+package codegen
+
+import "testing"
+
+// TestInline checks that function literal callbacks are spliced into
+// pipelines when it is safe to do so, and passed as arguments otherwise.
+// Inlined callbacks are omitted from the arguments of the pipeline, and the
+// variables they capture are added.
+func TestInline(t *testing.T) {
+	tests := []struct {
+		expr string
+		args string // the arguments passed to the pipeline
+	}{
+		{`xs.filter(func(x int) bool { return x > 3 }).morph(func(x int) int { return x * 2 })`, ``},
+		{`xs.morph(func(x int) int { return x + 1 }).fold(func(a, b int) int { return a + b }, 0)`, `0`},
+		{`xs.filter(func(x int) bool { return x > k }).take(2)`, `2, k`},
+		{`xs.filter(func(x int) bool { return x > limit }).take(2)`, `2`},
+		{`xs.filter(func(x int) bool { return x > 0 }).morph(func(x int) float64 { return 1 })`, ``},
+		{`xs.filteri(func(i, x int) bool { return i%2 == 0 }).take(1)`, `1`},
+		{`xs.morph(func(x int) bool { return x < 0 || x > 9 }).fold(func(a, b bool) bool { return a && b }, true)`, `true`},
+		{`xs.filter(func(x int) bool { return true }).morph(func(x int) int { return 0 })`, `(func(x int) int literal)`}, // e1 must remain in use
+
+		// not inlined
+		{`xs.filter(func(x int) bool { return x > m }).take(2)`, `(func(x int) bool literal), 2`},                                                               // m is modified
+		{`xs.morph(func(x int) int { return x + acc }).fold(func(a, b int) int { return a }, 0)`, `(func(x int) int literal), (func(a, b int) int literal), 0`}, // acc would be shadowed
+		{`xs.filter(func(x int) bool { y := x * 2; return y > 3 }).take(2)`, `(func(x int) bool literal), 2`},                                                   // multiple statements
+		{`xs.filter(func(x int) bool { return []int{x}.contains(3) }).take(2)`, `(func(x int) bool literal), 2`},
+		{`first(xs.morph(func(x int) int { return x * 2 }).partition(func(x int) bool { return x > no }))`, `(func(x int) bool literal)`},  // no would be shadowed by a result
+		{`first(xs.morph(func(x int) int { return x * 2 }).partition(func(x int) bool { return x > yes }))`, `(func(x int) bool literal)`}, // yes would clash with a result
+	}
+
+	decls := `
+var limit = 3
+
+var no = 3
+
+func first(a, b []int) []int { return a }
+`
+	for _, test := range tests {
+		code, err := compileMain(nil, decls, `
+	xs := []int{1, 2, 3}
+	k := 1
+	m := 1
+	m++
+	acc := 1
+	yes := 1
+	_, _, _, _ = k, m, acc, yes
+	_ = `+test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if args, ok := pipelineArgs(code); !ok {
+			t.Errorf("%s: expected pipeline, got:\n%s", test.expr, code)
+		} else if args != test.args {
+			t.Errorf("%s: expected pipeline(%s), got pipeline(%s)", test.expr, test.args, args)
+		}
+		if err := checkGenerated(code); err != nil {
+			t.Errorf("%s: generated code does not type-check: %v\n%s", test.expr, err, code)
+		}
+	}
+}
@@ -32,6 +32,9 @@ package codegen
 //    #next
 //    return filtered
 //
+// (The slice is actually declared with an #alloc directive, which may
+// preallocate it; see alloc.go.)
+//
 // We then insert the "setup" section of each transformation. Most
 // transformations do not require additional setup, so nothing is added in
 // this example.
@@ -54,7 +57,7 @@ package codegen
 //
 //    // takeWhile
 //    if !even(#e) { // #e -> e1
-//        break
+//        #break
 //    }
 //    #next
 //
@@ -90,6 +93,21 @@ package codegen
 //    }
 //    return filtered
 //
+// Some transformations, such as flatten, produce several values for each
+// value they receive. Their op opens a nested loop, and the ops of successive
+// transformations are inserted inside it:
+//
+//    // flatten
+//    for _, #+e := range #e {
+//        #next
+//    }
+//
+// A continue statement still skips to the next value in a nested loop, but a
+// break statement would only exit the innermost loop. This is why ops use the
+// #break directive to stop the pipeline: it becomes a labeled break out of
+// the outermost loop if the pipeline contains a nested loop, and a plain
+// break otherwise.
+//
 // Lastly, we must rewrite the callsite. The chained methods are replaced
 // with a single call that combines the arguments to each of the calls. In our
 // example:
@@ -116,34 +134,70 @@ type transformation struct {
 
 	// outline initializes the value to be returned and ends with a return
 	// statement. Only the outline of the primary transformation is inserted.
+	// A returned slice is declared with "#alloc(name, type, #cap)", where
+	// #cap is the estimated capacity of the slice.
 	outline string
 	// setup contains any declarations required by 'op'. This is only needed
 	// by transformations whose 'op' is not stateless, such as dropWhile. If
-	// empty, setup is assumed to equal "#next".
+	// empty, setup is assumed to equal "#next". Since a transformation may
+	// appear more than once in a pipeline, the names it declares must be
+	// suffixed with an argument (e.g. ntaken#arg1) or with #stage, the
+	// position of the transformation in the pipeline.
 	setup string
 	// loop is the for statement used by the transformation. It must
 	// contain the declaration of the variable x.
 	loop string
 	// op is the meat of the transformation. It may declare new variables or
-	// issue control statements (e.g. break, continue). op should not contain
-	// a return statement. If empty, op is assumed to equal "#next".
+	// issue control statements (e.g. continue). To stop the pipeline, op must
+	// use the #break directive rather than a bare break statement. op should
+	// not contain a return statement. If empty, op is assumed to equal
+	// "#next".
 	op string
 	// cons is the statement that folds the final variable into the
 	// accumulated value to be returned. Only the cons of the primary
 	// transformation is inserted. cons does not contain a #next directive.
 	cons string
+	// preservesIndex indicates that each value produced by the transformation
+	// is at the same position as the value it received, i.e. the
+	// transformation may only drop trailing values. Only such
+	// transformations may precede one that uses the #i directive, which
+	// refers to the index of the current value in the original receiver. The
+	// loop of these transformations (and of those that use #i) must declare
+	// #i.
+	preservesIndex bool
+	// nested indicates that op opens a nested loop, producing any number of
+	// values for each value it receives.
+	nested bool
+	// source indicates that the transformation can only begin a pipeline,
+	// e.g. because each of its values depends on several receiver elements.
+	// Source transformations have no outline, op, or cons.
+	source bool
+	// length relates the number of values produced by the transformation to
+	// the number it receives. It is used to estimate the capacity of the
+	// slice returned by the pipeline; see alloc.go.
+	length lengthKind
+	// hint is the argument, if any, supplied by the caller as an estimate of
+	// the number of values produced by the transformation. It takes
+	// precedence over length.
+	hint string
 
 	// typeFn returns the types of the transformation (T, U, etc.) given its
 	// calling context.
 	typeFn func(*ast.SelectorExpr, []ast.Expr, map[ast.Expr]types.TypeAndValue) []types.Type
 }
 
-func (t transformation) specify(call *ast.CallExpr, nargs int, exprTypes map[ast.Expr]types.TypeAndValue) transformation {
+// usesIndex reports whether t refers to the #i directive outside of its
+// loop.
+func (t transformation) usesIndex() bool {
+	return strings.Contains(t.op, "#i") || strings.Contains(t.cons, "#i")
+}
+
+func (t transformation) specify(call *ast.CallExpr, stage, nargs int, exprTypes map[ast.Expr]types.TypeAndValue) transformation {
 	// make a copy of t
 	s := t
 	s.params = append([]string(nil), t.params...)
 
-	templs := []*string{&s.recv, &s.ret, &s.outline, &s.setup, &s.loop, &s.op, &s.cons}
+	templs := []*string{&s.recv, &s.ret, &s.outline, &s.setup, &s.loop, &s.op, &s.cons, &s.hint}
 	for i := range s.params {
 		templs = append(templs, &s.params[i])
 	}
@@ -158,25 +212,21 @@ func (t transformation) specify(call *ast.CallExpr, nargs int, exprTypes map[ast
 		for i := range call.Args {
 			*templ = strings.Replace(*templ, "#arg"+strconv.Itoa(i+1), "__plyarg_"+strconv.Itoa(i+nargs), -1)
 		}
+		*templ = strings.Replace(*templ, "#stage", strconv.Itoa(stage), -1)
 		// trim whitespace
 		*templ = strings.TrimSpace(*templ)
 	}
 	return s
 }
 
-var safePipeName = func() func() string {
-	count := 0
-	return func() string {
-		count++
-		return "__plypipe_" + strconv.Itoa(count)
-	}
-}()
-
 type pipeline struct {
 	kn  int // k1, k2, k3...
 	en  int // e1, e2, e3...
 	fns []*ast.CallExpr
 	ts  []transformation
+	// callbacks are the arguments that may be inlined, keyed by their
+	// position among the arguments of fns.
+	callbacks map[int]*callback
 }
 
 // addSector replaces the #next directive in outer with inner. It also sets
@@ -201,40 +251,71 @@ func (p *pipeline) addSector(outer, inner string) string {
 	code = strings.Replace(code, "#k", "k"+strconv.Itoa(p.kn), -1)
 	if strings.Contains(code, "#+k") {
 		p.kn++
-		code = strings.Replace(code, "#+k", "k"+strconv.Itoa(p.en), -1)
+		code = strings.Replace(code, "#+k", "k"+strconv.Itoa(p.kn), -1)
 	}
 
 	return code
 }
 
 // gen generates a type, method, and rewriter for the given pipeline.
-func (p *pipeline) gen() (name, code string, r rewriter) {
+func (p *pipeline) gen(n *namer) (name, code string, r rewriter) {
 	first, last := p.ts[0], p.ts[len(p.ts)-1]
 
-	// begin with outline of last fn
-	code = last.outline
+	// begin with outline of last fn, estimating the capacity of the slice it
+	// returns
+	code = strings.Replace(last.outline, "#cap", p.capacity(), 1)
 	// add setup of each fn
 	for _, fn := range p.ts {
 		code = p.addSector(code, fn.setup)
 	}
-	// insert loop of first fn
-	code = p.addSector(code, first.loop)
+	// insert loop of first fn, labeling it if a #break might otherwise be
+	// inside a nested loop
+	var nested, breaks, indexed bool
+	for _, fn := range p.ts {
+		nested = nested || fn.nested
+		breaks = breaks || strings.Contains(fn.op, "#break")
+		indexed = indexed || fn.usesIndex()
+	}
+	loop, breakStmt := first.loop, "break"
+	if nested && breaks {
+		loop = strings.Replace(loop, "for ", "pipeline:\n\tfor ", 1)
+		breakStmt = "break pipeline"
+	}
+	code = p.addSector(code, loop)
 	// add op of each fn
 	for _, fn := range p.ts {
 		code = p.addSector(code, fn.op)
 	}
 	// add cons of last fn
 	code = p.addSector(code, last.cons)
+	code = strings.Replace(code, "#break", breakStmt, -1)
+	// the index is only declared if it is used
+	if indexed {
+		code = strings.Replace(code, "#i", "i", -1)
+	} else {
+		code = strings.Replace(code, "#i", "_", -1)
+	}
 
-	// add type and method signature
+	// splice inlined callbacks into the body
+	code, inlined, captures := p.inline(code)
+
+	// add type and method signature, omitting the parameters of inlined
+	// callbacks and adding parameters for the variables they capture
 	var params []string
+	nparams := 0
 	for _, t := range p.ts {
 		for _, paramType := range t.params {
-			param := "__plyarg_" + strconv.Itoa(len(params)) + " " + paramType
-			params = append(params, param)
+			if !inlined[nparams] {
+				param := "__plyarg_" + strconv.Itoa(nparams) + " " + paramType
+				params = append(params, param)
+			}
+			nparams++
 		}
 	}
-	name = safePipeName()
+	for _, v := range captures {
+		params = append(params, v.Name()+" "+v.Type().String())
+	}
+	name = n.pipeName()
 	code = strings.NewReplacer(
 		"#name", name,
 		"#T", first.recv,
@@ -251,8 +332,17 @@ func (recv #name) pipeline(#params) #ret {
 
 	// collect args
 	var args []ast.Expr
+	i := 0
 	for _, fn := range p.fns {
-		args = append(args, fn.Args...)
+		for _, arg := range fn.Args {
+			if !inlined[i] {
+				args = append(args, arg)
+			}
+			i++
+		}
+	}
+	for _, v := range captures {
+		args = append(args, ast.NewIdent(v.Name()))
 	}
 
 	// rewriter
@@ -271,7 +361,7 @@ func (recv #name) pipeline(#params) #ret {
 	return
 }
 
-func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndValue) *pipeline {
+func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndValue, selections map[*ast.SelectorExpr]*types.Selection) *pipeline {
 	p := &pipeline{kn: 1, en: 1}
 
 	// iterate through chain, which will be in reverse order. Lookup the
@@ -279,6 +369,7 @@ func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndVa
 	// transformation is found, or if certain special conditions are
 	// satisfied (e.g. reverse).
 	haveReverse := false
+	needIndex := false
 	for _, call := range chain {
 		e := call.Fun.(*ast.SelectorExpr)
 		if _, ok := exprTypes[e.X]; !ok {
@@ -290,6 +381,10 @@ func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndVa
 			// pipelines are only supported on slices and maps
 			break
 		}
+		if !isPlyMethod(e, selections) {
+			// method name override
+			break
+		}
 		methodName := e.Sel.Name
 		if isSlice {
 			methodName += "_slice"
@@ -297,12 +392,19 @@ func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndVa
 			methodName += "_map"
 		}
 
-		if hasMethod(e.X, methodName, exprTypes) {
-			// method name override
-			break
-		}
 		if methodName == "fold_slice" && len(call.Args) == 1 {
 			methodName = "fold1_slice"
+		} else if methodName == "dedupSorted_slice" && len(call.Args) == 1 {
+			methodName = "dedupSortedFn_slice"
+		}
+		hinted := (methodName == "flatten_slice" && len(call.Args) == 1) ||
+			(methodName == "flatMorph_slice" && len(call.Args) == 2)
+		switch methodName {
+		case "contains_slice", "indexOf_slice", "lastIndexOf_slice":
+			// if T is not comparable, the argument must be nil
+			if !types.Comparable(exprTypes[e.X].Type.Underlying().(*types.Slice).Elem()) {
+				methodName = strings.TrimSuffix(methodName, "_slice") + "Nil_slice"
+			}
 		}
 
 		// lookup the transformation
@@ -310,10 +412,27 @@ func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndVa
 		if !ok {
 			break
 		}
+		if hinted {
+			// the last argument is a capacity hint
+			t.params = append(t.params[:len(t.params):len(t.params)], "int")
+			t.hint = "#arg" + strconv.Itoa(len(call.Args))
+		}
+		// if a later transformation uses the index of its values in the
+		// receiver, it must not be affected by earlier transformations
+		if needIndex && !t.preservesIndex {
+			break
+		}
+		needIndex = needIndex || t.usesIndex()
+
 		// un-reverse the chain
 		p.ts = append([]transformation{t}, p.ts...)
 		p.fns = append([]*ast.CallExpr{call}, p.fns...)
 
+		// source transformations must begin the pipeline
+		if t.source {
+			break
+		}
+
 		// only one reverse is allowed per pipeline, and it must be at either
 		// the beginning or the end
 		if methodName == "reverse_slice" {
@@ -338,17 +457,42 @@ func buildPipeline(chain []*ast.CallExpr, exprTypes map[ast.Expr]types.TypeAndVa
 		return nil
 	}
 
+	// keys and elems loop over only the keys or elements of their receiver.
+	// After another map transformation, they must instead select the key or
+	// element that the transformation produced.
+	for i := 1; i < len(p.ts); i++ {
+		switch p.fns[i].Fun.(*ast.SelectorExpr).Sel.Name {
+		case "keys":
+			p.ts[i] = transformations["keysChained_map"]
+		case "elems":
+			p.ts[i] = transformations["elemsChained_map"]
+		}
+	}
+
 	// fully specify each transformation (can't be done in previous loop
 	// because order matters)
 	nargs := 0
 	for i := range p.ts {
-		p.ts[i] = p.ts[i].specify(p.fns[i], nargs, exprTypes)
+		p.ts[i] = p.ts[i].specify(p.fns[i], i, nargs, exprTypes)
 		nargs += len(p.fns[i].Args)
 	}
 
 	return p
 }
 
+// CanPipeline reports whether calls to the ply method name on a slice (or
+// map, if isMap is set) can be combined with adjacent calls into a single
+// pipeline.
+func CanPipeline(name string, isMap bool) bool {
+	if isMap {
+		name += "_map"
+	} else {
+		name += "_slice"
+	}
+	_, ok := transformations[name]
+	return ok
+}
+
 var transformations = map[string]transformation{
 	// Slice methods
 
@@ -374,31 +518,31 @@ var transformations = map[string]transformation{
 		typeFn: justSliceElem,
 	},
 
-	"any_slice": transformation{
+	"alli_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T) bool`},
+		params: []string{`func(int, #T) bool`},
 		ret:    `bool`,
 
 		outline: `
 	#next
-	return false
+	return true
 `,
 		loop: `
-	for _, #e := range recv {
+	for #i, #e := range recv {
 		#next
 	}
 `,
 		cons: `
-		if #arg1(#e) {
-			return true
+		if !#arg1(#i, #e) {
+			return false
 		}
 `,
 		typeFn: justSliceElem,
 	},
 
-	"contains_slice": transformation{
+	"any_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`#T`},
+		params: []string{`func(#T) bool`},
 		ret:    `bool`,
 
 		outline: `
@@ -411,16 +555,16 @@ var transformations = map[string]transformation{
 	}
 `,
 		cons: `
-		if #e == #arg1 {
+		if #arg1(#e) {
 			return true
 		}
 `,
 		typeFn: justSliceElem,
 	},
 
-	"containsNil_slice": transformation{
+	"anyi_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`#T`}, // unused
+		params: []string{`func(int, #T) bool`},
 		ret:    `bool`,
 
 		outline: `
@@ -428,121 +572,98 @@ var transformations = map[string]transformation{
 	return false
 `,
 		loop: `
-	for _, #e := range recv {
+	for #i, #e := range recv {
 		#next
 	}
 `,
 		cons: `
-		if #e == nil {
+		if #arg1(#i, #e) {
 			return true
 		}
 `,
 		typeFn: justSliceElem,
 	},
 
-	"drop_slice": transformation{
+	"argmax_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`int`},
-		ret:    `[]#T`,
+		params: nil,
+		ret:    `int`,
 
 		outline: `
-	var undropped []#T
-	#next
-	return undropped
-`,
-		setup: `
-	ndropped#arg1 := 0
+	argmax, nseen := -1, 0
+	var max #T
 	#next
+	return argmax
 `,
 		loop: `
-	if #arg1 > len(recv) {
-		#arg1 = len(recv)
-	}
-	ndropped#arg1 = #arg1
-	for _, #e := range recv[#arg1:] {
+	for _, #e := range recv {
 		#next
 	}
-`,
-		op: `
-		if ndropped#arg1++; ndropped#arg1 <= #arg1 {
-			continue
-		}
-		#next
 `,
 		cons: `
-		undropped = append(undropped, #e)
+		if argmax == -1 || max < #e || (max != max && #e == #e) {
+			argmax, max = nseen, #e
+		}
+		nseen++
 `,
 		typeFn: justSliceElem,
 	},
 
-	"dropWhile_slice": transformation{
+	"argmin_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T) bool`},
-		ret:    `[]#T`,
+		params: nil,
+		ret:    `int`,
 
 		outline: `
-	var undropped []#T
-	#next
-	return undropped
-`,
-		setup: `
-	stilldropping#arg1 := true
+	argmin, nseen := -1, 0
+	var min #T
 	#next
+	return argmin
 `,
 		loop: `
 	for _, #e := range recv {
 		#next
 	}
-`,
-		op: `
-		stilldropping#arg1 = stilldropping#arg1 && #arg1(#e)
-		if stilldropping#arg1 {
-			continue
-		}
-		#next
 `,
 		cons: `
-		undropped = append(undropped, #e)
+		if argmin == -1 || #e < min || (#e != #e && min == min) {
+			argmin, min = nseen, #e
+		}
+		nseen++
 `,
 		typeFn: justSliceElem,
 	},
 
-	"filter_slice": transformation{
+	"chunk_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T) bool`},
-		ret:    `[]#T`,
+		params: []string{`int`},
+		ret:    `[][]#T`,
 
-		outline: `
-	var filtered []#T
-	#next
-	return filtered
-`,
 		loop: `
-	for _, #e := range recv {
-		#next
+	if #arg1 <= 0 {
+		panic("chunk: size must be positive")
 	}
-`,
-		op: `
-		if !#arg1(#e) {
-			continue
+	for #k := 0; #k < len(recv); #k += #arg1 {
+		chunkEnd := #k + #arg1
+		if chunkEnd > len(recv) {
+			chunkEnd = len(recv)
 		}
+		#e := recv[#k:chunkEnd:chunkEnd]
 		#next
+	}
 `,
-		cons: `
-		filtered = append(filtered, #e)
-`,
+		source: true,
 		typeFn: justSliceElem,
 	},
 
-	"fold_slice": transformation{
+	"contains_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#U, #T) #U`, `#U`},
-		ret:    `#U`,
+		params: []string{`#T`},
+		ret:    `bool`,
 
 		outline: `
-	acc := #arg1
 	#next
-	return acc
+	return false
 `,
 		loop: `
 	for _, #e := range recv {
@@ -550,29 +671,21 @@ var transformations = map[string]transformation{
 	}
 `,
 		cons: `
-		acc = #arg1(acc, #e)
+		if #e == #arg1 {
+			return true
+		}
 `,
-		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
-			sig := exprTypes[args[0]].Type.(*types.Signature)
-			T := sig.Params().At(1).Type()
-			U := sig.Params().At(0).Type()
-			return []types.Type{T, U}
-		},
+		typeFn: justSliceElem,
 	},
 
-	"fold1_slice": transformation{
+	"containsNil_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T, #T) #T`},
-		ret:    `#T`,
+		params: []string{`#T`}, // unused
+		ret:    `bool`,
 
 		outline: `
-	var acc #T
-	var accset bool
 	#next
-	if !accset {
-		panic("fold of empty slice")
-	}
-	return acc
+	return false
 `,
 		loop: `
 	for _, #e := range recv {
@@ -580,23 +693,22 @@ var transformations = map[string]transformation{
 	}
 `,
 		cons: `
-		if !accset {
-			acc = #e
-			accset = true
-		} else {
-			acc = #arg1(acc, #e)
+		if #e == nil {
+			return true
 		}
 `,
 		typeFn: justSliceElem,
 	},
 
-	"foreach_slice": transformation{
+	"count_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T)`},
-		ret:    ``,
+		params: []string{`func(#T) bool`},
+		ret:    `int`,
 
 		outline: `
+	var count int
 	#next
+	return count
 `,
 		loop: `
 	for _, #e := range recv {
@@ -604,32 +716,30 @@ var transformations = map[string]transformation{
 	}
 `,
 		cons: `
-		#arg1(#e)
+		if #arg1(#e) {
+			count++
+		}
 `,
 		typeFn: justSliceElem,
 	},
 
-	"morph_slice": transformation{
+	"countBy_slice": transformation{
 		recv:   `[]#T`,
 		params: []string{`func(#T) #U`},
-		ret:    `[]#U`,
+		ret:    `map[#U]int`,
 
 		outline: `
-	var morphed []#U
+	counts := make(map[#U]int)
 	#next
-	return morphed
+	return counts
 `,
 		loop: `
 	for _, #e := range recv {
 		#next
 	}
-`,
-		op: `
-		#+e := #arg1(#e)
-		#next
 `,
 		cons: `
-		morphed = append(morphed, #e)
+		counts[#arg1(#e)]++
 `,
 		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
 			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
@@ -639,97 +749,131 @@ var transformations = map[string]transformation{
 		},
 	},
 
-	"reverse_slice": transformation{
+	"dedupSorted_slice": transformation{
 		recv:   `[]#T`,
 		params: nil,
 		ret:    `[]#T`,
 
 		outline: `
-	var reversed []#T
+	#alloc(dedup, []#T, #cap)
 	#next
-	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
-		reversed[i], reversed[j] = reversed[j], reversed[i]
-	}
-	return reversed
+	return dedup
 `,
+
+		setup: `
+	var dedupPrev#stage #T
+	dedupStarted#stage := false
+	#next
+`,
+
 		loop: `
-	for i := range recv {
-		#e := recv[len(recv)-i-1]
+	for _, #e := range recv {
 		#next
 	}
 `,
+
+		op: `
+		if dedupStarted#stage && !(dedupPrev#stage < #e || (dedupPrev#stage != dedupPrev#stage && #e == #e)) {
+			continue
+		}
+		dedupPrev#stage, dedupStarted#stage = #e, true
+		#next
+`,
+
 		cons: `
-		reversed = append(reversed, #e)
+		dedup = append(dedup, #e)
 `,
+		length: lengthFewer,
 		typeFn: justSliceElem,
 	},
 
-	"take_slice": transformation{
+	"dedupSortedFn_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`int`},
+		params: []string{`func(#T, #T) bool`},
 		ret:    `[]#T`,
 
 		outline: `
-	var taken []#T
+	#alloc(dedup, []#T, #cap)
 	#next
-	return taken
+	return dedup
 `,
+
 		setup: `
-	ntaken#arg1 := 0
+	var dedupPrev#stage #T
+	dedupStarted#stage := false
 	#next
 `,
+
 		loop: `
 	for _, #e := range recv {
 		#next
 	}
 `,
+
 		op: `
-		if ntaken#arg1++; ntaken#arg1 > #arg1 {
-			break
+		if dedupStarted#stage && !#arg1(dedupPrev#stage, #e) {
+			continue
 		}
+		dedupPrev#stage, dedupStarted#stage = #e, true
 		#next
 `,
+
 		cons: `
-		taken = append(taken, #e)
+		dedup = append(dedup, #e)
 `,
+		length: lengthFewer,
 		typeFn: justSliceElem,
 	},
 
-	"takeWhile_slice": transformation{
+	"drop_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T) bool`},
+		params: []string{`int`},
 		ret:    `[]#T`,
 
 		outline: `
-	var taken []#T
+	#alloc(undropped, []#T, #cap)
+	#next
+	return undropped
+`,
+		setup: `
+	ndropped#arg1 := 0
 	#next
-	return taken
 `,
 		loop: `
-	for _, #e := range recv {
+	if #arg1 > len(recv) {
+		#arg1 = len(recv)
+	}
+	ndropped#arg1 = #arg1
+	for _, #e := range recv[#arg1:] {
 		#next
 	}
 `,
 		op: `
-		if !arg1(#e) {
-			break
+		if ndropped#arg1++; ndropped#arg1 <= #arg1 {
+			continue
 		}
 		#next
 `,
 		cons: `
-		taken = append(taken, #e)
+		undropped = append(undropped, #e)
 `,
+		length: lengthFewer,
 		typeFn: justSliceElem,
 	},
 
-	"tee_slice": transformation{
+	"dropWhile_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T)`},
+		params: []string{`func(#T) bool`},
 		ret:    `[]#T`,
 
 		outline: `
+	#alloc(undropped, []#T, #cap)
+	#next
+	return undropped
+`,
+		setup: `
+	stilldropping#arg1 := true
 	#next
-	return recv
 `,
 		loop: `
 	for _, #e := range recv {
@@ -737,31 +881,838 @@ var transformations = map[string]transformation{
 	}
 `,
 		op: `
-		#arg1(#e)
+		stilldropping#arg1 = stilldropping#arg1 && #arg1(#e)
+		if stilldropping#arg1 {
+			continue
+		}
 		#next
 `,
+		cons: `
+		undropped = append(undropped, #e)
+`,
+		length: lengthFewer,
 		typeFn: justSliceElem,
 	},
 
-	"toMap_slice": transformation{
+	"filter_slice": transformation{
 		recv:   `[]#T`,
-		params: []string{`func(#T) #U`},
-		ret:    "map[#T]#U",
+		params: []string{`func(#T) bool`},
+		ret:    `[]#T`,
 
 		outline: `
-	set := make(map[#T]#U)
+	#alloc(filtered, []#T, #cap)
 	#next
-	return set
+	return filtered
 `,
 		loop: `
 	for _, #e := range recv {
 		#next
 	}
 `,
-		cons: `
-		set[#e] = #arg1(#e)
-`,
-		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+		op: `
+		if !#arg1(#e) {
+			continue
+		}
+		#next
+`,
+		cons: `
+		filtered = append(filtered, #e)
+`,
+		length: lengthFewer,
+		typeFn: justSliceElem,
+	},
+
+	"filteri_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(int, #T) bool`},
+		ret:    `[]#T`,
+
+		outline: `
+	#alloc(filtered, []#T, #cap)
+	#next
+	return filtered
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		if !#arg1(#i, #e) {
+			continue
+		}
+		#next
+`,
+		cons: `
+		filtered = append(filtered, #e)
+`,
+		length: lengthFewer,
+		typeFn: justSliceElem,
+	},
+
+	"find_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) bool`},
+		ret:    `(#T, bool)`,
+
+		outline: `
+	#next
+	var zero #T
+	return zero, false
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #arg1(#e) {
+			return #e, true
+		}
+`,
+		typeFn: justSliceElem,
+	},
+
+	"findIndex_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) bool`},
+		ret:    `int`,
+
+		outline: `
+	var nseen int
+	#next
+	return -1
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #arg1(#e) {
+			return nseen
+		}
+		nseen++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"flatMorph_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    `[]#V`,
+
+		outline: `
+	#alloc(flatMorphed, []#V, #cap)
+	#next
+	return flatMorphed
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		for _, #+e := range #arg1(#e) {
+			#next
+		}
+`,
+		cons: `
+		flatMorphed = append(flatMorphed, #e)
+`,
+		nested: true,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(0).Type()
+			U := sig.Results().At(0).Type()
+			V := U.Underlying().(*types.Slice).Elem()
+			return []types.Type{T, U, V}
+		},
+	},
+
+	"flatten_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `[]#U`,
+
+		outline: `
+	#alloc(flattened, []#U, #cap)
+	#next
+	return flattened
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		for _, #+e := range #e {
+			#next
+		}
+`,
+		cons: `
+		flattened = append(flattened, #e)
+`,
+		nested: true,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+			U := T.Underlying().(*types.Slice).Elem()
+			return []types.Type{T, U}
+		},
+	},
+
+	"fold_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#U, #T) #U`, `#U`},
+		ret:    `#U`,
+
+		outline: `
+	acc := #arg2
+	#next
+	return acc
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		acc = #arg1(acc, #e)
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(1).Type()
+			U := sig.Params().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"fold1_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T, #T) #T`},
+		ret:    `#T`,
+
+		outline: `
+	var acc #T
+	var accset bool
+	#next
+	if !accset {
+		panic("fold of empty slice")
+	}
+	return acc
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if !accset {
+			acc = #e
+			accset = true
+		} else {
+			acc = #arg1(acc, #e)
+		}
+`,
+		typeFn: justSliceElem,
+	},
+
+	"foldi_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#U, int, #T) #U`, `#U`},
+		ret:    `#U`,
+
+		outline: `
+	acc := #arg2
+	#next
+	return acc
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		acc = #arg1(acc, #i, #e)
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(2).Type()
+			U := sig.Params().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"foreach_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T)`},
+		ret:    ``,
+
+		outline: `
+	#next
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		#arg1(#e)
+`,
+		typeFn: justSliceElem,
+	},
+
+	"foreachi_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(int, #T)`},
+		ret:    ``,
+
+		outline: `
+	#next
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		#arg1(#i, #e)
+`,
+		typeFn: justSliceElem,
+	},
+
+	"groupBy_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    `map[#U][]#T`,
+
+		outline: `
+	groups := make(map[#U][]#T)
+	#next
+	return groups
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		key := #arg1(#e)
+		groups[key] = append(groups[key], #e)
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(0).Type()
+			U := sig.Results().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"indexOf_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`#T`},
+		ret:    `int`,
+
+		outline: `
+	var nseen int
+	#next
+	return -1
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #e == #arg1 {
+			return nseen
+		}
+		nseen++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"indexOfNil_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`#T`}, // unused
+		ret:    `int`,
+
+		outline: `
+	var nseen int
+	#next
+	return -1
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #e == nil {
+			return nseen
+		}
+		nseen++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"lastIndexOf_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`#T`},
+		ret:    `int`,
+
+		outline: `
+	last, nseen := -1, 0
+	#next
+	return last
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #e == #arg1 {
+			last = nseen
+		}
+		nseen++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"lastIndexOfNil_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`#T`}, // unused
+		ret:    `int`,
+
+		outline: `
+	last, nseen := -1, 0
+	#next
+	return last
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #e == nil {
+			last = nseen
+		}
+		nseen++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"max_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `(#T, bool)`,
+
+		outline: `
+	var max #T
+	var ok bool
+	#next
+	return max, ok
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if !ok || max < #e || (max != max && #e == #e) {
+			max, ok = #e, true
+		}
+`,
+		typeFn: justSliceElem,
+	},
+
+	"maxBy_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    `(#T, bool)`,
+
+		outline: `
+	var max #T
+	var maxKey #U
+	var ok bool
+	#next
+	return max, ok
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if key := #arg1(#e); !ok || maxKey < key || (maxKey != maxKey && key == key) {
+			max, maxKey, ok = #e, key, true
+		}
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(0).Type()
+			U := sig.Results().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"mean_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `(float64, bool)`,
+
+		outline: `
+	var sum float64
+	var n int
+	#next
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		sum += float64(#e)
+		n++
+`,
+		typeFn: justSliceElem,
+	},
+
+	"min_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `(#T, bool)`,
+
+		outline: `
+	var min #T
+	var ok bool
+	#next
+	return min, ok
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if !ok || #e < min || (#e != #e && min == min) {
+			min, ok = #e, true
+		}
+`,
+		typeFn: justSliceElem,
+	},
+
+	"minBy_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    `(#T, bool)`,
+
+		outline: `
+	var min #T
+	var minKey #U
+	var ok bool
+	#next
+	return min, ok
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if key := #arg1(#e); !ok || key < minKey || (key != key && minKey == minKey) {
+			min, minKey, ok = #e, key, true
+		}
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(0).Type()
+			U := sig.Results().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"morph_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    `[]#U`,
+
+		outline: `
+	#alloc(morphed, []#U, #cap)
+	#next
+	return morphed
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+e := #arg1(#e)
+		#next
+`,
+		cons: `
+		morphed = append(morphed, #e)
+`,
+		preservesIndex: true,
+		length:         lengthSame,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(0).Type()
+			U := sig.Results().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"morphi_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(int, #T) #U`},
+		ret:    `[]#U`,
+
+		outline: `
+	#alloc(morphed, []#U, #cap)
+	#next
+	return morphed
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+e := #arg1(#i, #e)
+		#next
+`,
+		cons: `
+		morphed = append(morphed, #e)
+`,
+		preservesIndex: true,
+		length:         lengthSame,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
+			T := sig.Params().At(1).Type()
+			U := sig.Results().At(0).Type()
+			return []types.Type{T, U}
+		},
+	},
+
+	"pairs_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `[][2]#T`,
+
+		loop: `
+	for #k := 0; #k+1 < len(recv); #k++ {
+		#e := [2]#T{recv[#k], recv[#k+1]}
+		#next
+	}
+`,
+		source: true,
+		typeFn: justSliceElem,
+	},
+
+	"partition_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) bool`},
+		ret:    `(yes, no []#T)`,
+
+		outline: `
+	#next
+	return
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		if #arg1(#e) {
+			yes = append(yes, #e)
+		} else {
+			no = append(no, #e)
+		}
+`,
+		typeFn: justSliceElem,
+	},
+
+	"product_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `#T`,
+
+		outline: `
+	product := #T(1)
+	#next
+	return product
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		product *= #e
+`,
+		typeFn: justSliceElem,
+	},
+
+	"reverse_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `[]#T`,
+
+		outline: `
+	#alloc(reversed, []#T, #cap)
+	#next
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return reversed
+`,
+		loop: `
+	for i := range recv {
+		#e := recv[len(recv)-i-1]
+		#next
+	}
+`,
+		cons: `
+		reversed = append(reversed, #e)
+`,
+		length: lengthSame,
+		typeFn: justSliceElem,
+	},
+
+	"sum_slice": transformation{
+		recv:   `[]#T`,
+		params: nil,
+		ret:    `#T`,
+
+		outline: `
+	var sum #T
+	#next
+	return sum
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		sum += #e
+`,
+		typeFn: justSliceElem,
+	},
+
+	"take_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`int`},
+		ret:    `[]#T`,
+
+		outline: `
+	#alloc(taken, []#T, #cap)
+	#next
+	return taken
+`,
+		setup: `
+	ntaken#arg1 := 0
+	#next
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		if ntaken#arg1++; ntaken#arg1 > #arg1 {
+			#break
+		}
+		#next
+`,
+		cons: `
+		taken = append(taken, #e)
+`,
+		preservesIndex: true,
+		length:         lengthFewer,
+		typeFn:         justSliceElem,
+	},
+
+	"takeWhile_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) bool`},
+		ret:    `[]#T`,
+
+		outline: `
+	#alloc(taken, []#T, #cap)
+	#next
+	return taken
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		if !#arg1(#e) {
+			#break
+		}
+		#next
+`,
+		cons: `
+		taken = append(taken, #e)
+`,
+		preservesIndex: true,
+		length:         lengthFewer,
+		typeFn:         justSliceElem,
+	},
+
+	"tee_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T)`},
+		ret:    `[]#T`,
+
+		outline: `
+	#next
+	return recv
+`,
+		loop: `
+	for #i, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#arg1(#e)
+		#next
+`,
+		preservesIndex: true,
+		length:         lengthSame,
+		typeFn:         justSliceElem,
+	},
+
+	"toMap_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`func(#T) #U`},
+		ret:    "map[#T]#U",
+
+		outline: `
+	set := make(map[#T]#U)
+	#next
+	return set
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		set[#e] = #arg1(#e)
+`,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
 			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
 			T := sig.Params().At(0).Type()
 			U := sig.Results().At(0).Type()
@@ -796,7 +1747,7 @@ var transformations = map[string]transformation{
 		ret:    `[]#T`,
 
 		outline: `
-	var unique []#T
+	#alloc(unique, []#T, #cap)
 	#next
 	return unique
 `,
@@ -823,6 +1774,25 @@ var transformations = map[string]transformation{
 		cons: `
 		unique = append(unique, #e)
 `,
+		length: lengthFewer,
+		typeFn: justSliceElem,
+	},
+
+	"window_slice": transformation{
+		recv:   `[]#T`,
+		params: []string{`int`},
+		ret:    `[][]#T`,
+
+		loop: `
+	if #arg1 <= 0 {
+		panic("window: size must be positive")
+	}
+	for #k := 0; #k+#arg1 <= len(recv); #k++ {
+		#e := recv[#k : #k+#arg1 : #k+#arg1]
+		#next
+	}
+`,
+		source: true,
 		typeFn: justSliceElem,
 	},
 
@@ -834,7 +1804,31 @@ var transformations = map[string]transformation{
 		ret:    `[]#U`,
 
 		outline: `
-	var elems []#U
+	#alloc(elems, []#U, #cap)
+	#next
+	return elems
+`,
+		loop: `
+	for _, #e := range recv {
+		#next
+	}
+`,
+		cons: `
+		elems = append(elems, #e)
+`,
+		length: lengthSame,
+		typeFn: justMapKeyElem,
+	},
+
+	// elems, after another map transformation. The key must be discarded
+	// explicitly, since the transformation may have declared it.
+	"elemsChained_map": transformation{
+		recv:   `map[#T]#U`,
+		params: nil,
+		ret:    `[]#U`,
+
+		outline: `
+	#alloc(elems, []#U, #cap)
 	#next
 	return elems
 `,
@@ -842,10 +1836,41 @@ var transformations = map[string]transformation{
 	for _, #e := range recv {
 		#next
 	}
+`,
+		op: `
+		_ = #k
+		#next
 `,
 		cons: `
 		elems = append(elems, #e)
 `,
+		length: lengthSame,
+		typeFn: justMapKeyElem,
+	},
+
+	"entries_map": transformation{
+		recv:   `map[#T]#U`,
+		params: nil,
+		ret:    `[]struct{Key #T; Val #U}`,
+
+		outline: `
+	#alloc(entries, []struct{Key #T; Val #U}, #cap)
+	#next
+	return entries
+`,
+		loop: `
+	for #k, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+e := struct{Key #T; Val #U}{#k, #e}
+		#next
+`,
+		cons: `
+		entries = append(entries, #e)
+`,
+		length: lengthSame,
 		typeFn: justMapKeyElem,
 	},
 
@@ -873,6 +1898,33 @@ var transformations = map[string]transformation{
 		cons: `
 		filtered[#k] = #e
 `,
+		length: lengthFewer,
+		typeFn: justMapKeyElem,
+	},
+
+	"invert_map": transformation{
+		recv:   `map[#T]#U`,
+		params: nil,
+		ret:    `map[#U]#T`,
+
+		outline: `
+	inverted := make(map[#U]#T)
+	#next
+	return inverted
+`,
+		loop: `
+	for #k, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+k, #+e := #e, #k
+		#next
+`,
+		cons: `
+		inverted[#k] = #e
+`,
+		length: lengthSame,
 		typeFn: justMapKeyElem,
 	},
 
@@ -882,7 +1934,7 @@ var transformations = map[string]transformation{
 		ret:    `[]#T`,
 
 		outline: `
-	var keys []#T
+	#alloc(keys, []#T, #cap)
 	#next
 	return keys
 `,
@@ -896,9 +1948,100 @@ var transformations = map[string]transformation{
 		cons: `
 		keys = append(keys, #e)
 `,
+		length: lengthSame,
+		typeFn: justMapKeyElem,
+	},
+
+	// keys, after another map transformation. The key becomes the element
+	// for subsequent transformations, and the element must be discarded
+	// explicitly, since the transformation may have declared it.
+	"keysChained_map": transformation{
+		recv:   `map[#T]#U`,
+		params: nil,
+		ret:    `[]#T`,
+
+		outline: `
+	#alloc(keys, []#T, #cap)
+	#next
+	return keys
+`,
+		loop: `
+	for #k := range recv {
+		#next
+	}
+`,
+		op: `
+		_ = #e
+		#+e := #k
+		#next
+`,
+		cons: `
+		keys = append(keys, #e)
+`,
+		length: lengthSame,
 		typeFn: justMapKeyElem,
 	},
 
+	"mapKeys_map": transformation{
+		recv:   `map[#T]#U`,
+		params: []string{`func(#T) #V`},
+		ret:    `map[#V]#U`,
+
+		outline: `
+	mapped := make(map[#V]#U)
+	#next
+	return mapped
+`,
+		loop: `
+	for #k, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+k := #arg1(#k)
+		#next
+`,
+		cons: `
+		mapped[#k] = #e
+`,
+		length: lengthSame,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			m := exprTypes[fn.X].Type.Underlying().(*types.Map)
+			V := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+			return []types.Type{m.Key(), m.Elem(), V}
+		},
+	},
+
+	"mapValues_map": transformation{
+		recv:   `map[#T]#U`,
+		params: []string{`func(#U) #V`},
+		ret:    `map[#T]#V`,
+
+		outline: `
+	mapped := make(map[#T]#V)
+	#next
+	return mapped
+`,
+		loop: `
+	for #k, #e := range recv {
+		#next
+	}
+`,
+		op: `
+		#+e := #arg1(#e)
+		#next
+`,
+		cons: `
+		mapped[#k] = #e
+`,
+		length: lengthSame,
+		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
+			m := exprTypes[fn.X].Type.Underlying().(*types.Map)
+			V := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+			return []types.Type{m.Key(), m.Elem(), V}
+		},
+	},
+
 	"morph_map": transformation{
 		recv:   `map[#T]#U`,
 		params: []string{`func(#T, #U) (#V, #W)`},
@@ -921,6 +2064,7 @@ var transformations = map[string]transformation{
 		cons: `
 		morphed[#k] = #e
 `,
+		length: lengthSame,
 		typeFn: func(fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) []types.Type {
 			sig := exprTypes[args[0]].Type.Underlying().(*types.Signature)
 			T := sig.Params().At(0).Type()
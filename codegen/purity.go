@@ -0,0 +1,423 @@
+package codegen
+
+// Purity analysis
+//
+// Pipelining changes how callbacks are called. In
+//
+//    xs.morph(fn).take(3)
+//
+// fn is called on every element of xs without pipelining, but only on the
+// first three with it. And in xs.filter(f).foreach(g), the calls to f and g
+// are interleaved, rather than all calls to f preceding all calls to g. Both
+// are invisible if the callbacks have no side effects, so before generating
+// a pipeline, each of its callbacks is classified as:
+//
+//    pure: it writes nothing outside of its own variables, and reads only
+//          its arguments and values that never change
+//    read-only: it writes nothing, but may read values that change, such as
+//          package-level variables or the elements of a slice
+//    effectful: anything else, e.g. it assigns to a captured variable,
+//          performs I/O, or calls a function that cannot be analyzed
+//
+// A pipeline is generated only if no stage preceding one that may stop the
+// pipeline early (e.g. take, or a terminal such as any) has an effectful
+// callback, and, if any stage has an effectful callback, the callbacks of all
+// other stages are pure. Otherwise, stages are split off the front of the
+// pipeline until these conditions hold; the split-off stages are compiled
+// separately, just as if they could not be pipelined at all.
+//
+// The analysis is conservative. Functions whose bodies are not available are
+// effectful, except for the package-level functions of a few standard
+// packages; so are recursive functions and calls through function values,
+// unless the value is a local variable initialized with a function literal
+// and never modified. Panics are not considered side effects.
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/lukechampine/ply/types"
+)
+
+type purity int
+
+const (
+	pure purity = iota
+	readOnly
+	effectful
+)
+
+// purePackages are the standard packages whose package-level functions are
+// known to be pure.
+var purePackages = map[string]bool{
+	"math":          true,
+	"math/bits":     true,
+	"strconv":       true,
+	"strings":       true,
+	"unicode":       true,
+	"unicode/utf16": true,
+	"unicode/utf8":  true,
+}
+
+// A purityAnalysis classifies the functions of a package.
+type purityAnalysis struct {
+	info    *types.Info
+	pkg     *types.Package
+	decls   map[types.Object]*ast.FuncDecl
+	lits    map[types.Object]*ast.FuncLit // local variables initialized with function literals
+	mutated map[types.Object]bool
+	memo    map[types.Object]purity
+}
+
+// newPurityAnalysis returns a purityAnalysis for the package comprising files.
+// The functions of the package are analyzed immediately, since their bodies
+// will be rewritten during specialization.
+func newPurityAnalysis(pkg *types.Package, files []*ast.File, info *types.Info) *purityAnalysis {
+	a := &purityAnalysis{
+		info:    info,
+		pkg:     pkg,
+		decls:   make(map[types.Object]*ast.FuncDecl),
+		lits:    make(map[types.Object]*ast.FuncLit),
+		mutated: make(map[types.Object]bool),
+		memo:    make(map[types.Object]purity),
+	}
+	for _, f := range files {
+		for obj := range mutatedVars(f, info) {
+			a.mutated[obj] = true
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncDecl:
+				if n.Body != nil {
+					a.decls[info.Defs[n.Name]] = n
+				}
+			case *ast.AssignStmt:
+				if n.Tok == token.DEFINE && len(n.Lhs) == len(n.Rhs) {
+					for i, lhs := range n.Lhs {
+						a.addLit(lhs, n.Rhs[i])
+					}
+				}
+			case *ast.ValueSpec:
+				if len(n.Names) == len(n.Values) {
+					for i, name := range n.Names {
+						a.addLit(name, n.Values[i])
+					}
+				}
+			}
+			return true
+		})
+	}
+	for obj := range a.decls {
+		a.funcPurity(obj.(*types.Func))
+	}
+	for obj := range a.lits {
+		a.varPurity(obj.(*types.Var))
+	}
+	return a
+}
+
+// addLit records that the variable declared by name is initialized with
+// value, if value is a function literal.
+func (a *purityAnalysis) addLit(name, value ast.Expr) {
+	id, ok := name.(*ast.Ident)
+	if !ok {
+		return
+	}
+	if lit, ok := unparen(value).(*ast.FuncLit); ok {
+		if obj := a.info.Defs[id]; obj != nil {
+			a.lits[obj] = lit
+		}
+	}
+}
+
+// funcPurity returns the purity of calling fn.
+func (a *purityAnalysis) funcPurity(fn *types.Func) purity {
+	if p, ok := a.memo[fn]; ok {
+		return p
+	}
+	if fn.Pkg() != nil && purePackages[fn.Pkg().Path()] && fn.Type().(*types.Signature).Recv() == nil {
+		return pure
+	}
+	// recursive calls are assumed to be effectful
+	a.memo[fn] = effectful
+	if decl, ok := a.decls[fn]; ok {
+		a.memo[fn] = a.body(decl)
+	}
+	return a.memo[fn]
+}
+
+// varPurity returns the purity of calling the function stored in v.
+func (a *purityAnalysis) varPurity(v *types.Var) purity {
+	if p, ok := a.memo[v]; ok {
+		return p
+	}
+	a.memo[v] = effectful
+	if lit, ok := a.lits[v]; ok && !a.mutated[v] {
+		a.memo[v] = a.body(lit)
+	}
+	return a.memo[v]
+}
+
+// callback returns the purity of calling the function value e.
+func (a *purityAnalysis) callback(e ast.Expr) purity {
+	switch e := unparen(e).(type) {
+	case *ast.FuncLit:
+		return a.body(e)
+	case *ast.Ident:
+		return a.objPurity(a.info.Uses[e])
+	case *ast.SelectorExpr:
+		// a qualified identifier or method value; the receiver of the
+		// latter is evaluated once, when the callback is passed
+		p := a.objPurity(a.info.Uses[e.Sel])
+		if _, ok := a.info.Selections[e]; ok {
+			p = maxPurity(p, a.body(e.X))
+		}
+		return p
+	case *ast.CallExpr:
+		// not and compose call only their arguments
+		if id, ok := unparen(e.Fun).(*ast.Ident); ok {
+			if b, ok := a.info.Uses[id].(*types.Ply); ok && (b.Name() == "not" || b.Name() == "compose") {
+				p := pure
+				for _, arg := range e.Args {
+					p = maxPurity(p, a.callback(arg))
+				}
+				return p
+			}
+		}
+	}
+	return effectful
+}
+
+// objPurity returns the purity of calling the function obj.
+func (a *purityAnalysis) objPurity(obj types.Object) purity {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return a.funcPurity(obj)
+	case *types.Var:
+		return a.varPurity(obj)
+	}
+	return effectful
+}
+
+// body returns the purity of executing root, which is a function or an
+// expression. Variables declared within root are its own.
+func (a *purityAnalysis) body(root ast.Node) purity {
+	local := func(obj types.Object) bool {
+		return obj.Pos() >= root.Pos() && obj.Pos() < root.End()
+	}
+	p := pure
+	ast.Inspect(root, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				if !a.localLvalue(lhs, local) {
+					p = effectful
+				}
+			}
+		case *ast.IncDecStmt:
+			if !a.localLvalue(n.X, local) {
+				p = effectful
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN && (!a.localLvalue(n.Key, local) || (n.Value != nil && !a.localLvalue(n.Value, local))) {
+				p = effectful
+			}
+			switch a.info.Types[n.X].Type.Underlying().(type) {
+			case *types.Chan:
+				p = effectful
+			case *types.Slice, *types.Map, *types.Pointer:
+				p = maxPurity(p, readOnly)
+			}
+		case *ast.SendStmt, *ast.GoStmt:
+			p = effectful
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				p = effectful
+			}
+		case *ast.StarExpr:
+			if !a.info.Types[n].IsType() {
+				p = maxPurity(p, readOnly)
+			}
+		case *ast.IndexExpr:
+			switch a.info.Types[n.X].Type.Underlying().(type) {
+			case *types.Slice, *types.Map, *types.Pointer:
+				p = maxPurity(p, readOnly)
+			}
+		case *ast.SelectorExpr:
+			if sel, ok := a.info.Selections[n]; ok && sel.Kind() == types.FieldVal && sel.Indirect() {
+				p = maxPurity(p, readOnly)
+			}
+		case *ast.CallExpr:
+			p = maxPurity(p, a.call(n))
+		case *ast.Ident:
+			if v, ok := a.info.Uses[n].(*types.Var); ok && !v.IsField() && !local(v) {
+				// a variable that may change
+				if v.Pkg() != a.pkg || a.mutated[v] || (v.Exported() && v.Parent() == a.pkg.Scope()) {
+					p = maxPurity(p, readOnly)
+				}
+			}
+		}
+		return p != effectful
+	})
+	return p
+}
+
+// localLvalue reports whether assigning to e only modifies a variable for
+// which local returns true.
+func (a *purityAnalysis) localLvalue(e ast.Expr, local func(types.Object) bool) bool {
+	for {
+		switch x := e.(type) {
+		case *ast.Ident:
+			if x.Name == "_" {
+				return true
+			}
+			obj := a.info.Uses[x]
+			if obj == nil {
+				obj = a.info.Defs[x]
+			}
+			return obj != nil && local(obj)
+		case *ast.ParenExpr:
+			e = x.X
+		case *ast.SelectorExpr:
+			if sel, ok := a.info.Selections[x]; !ok || sel.Indirect() {
+				return false
+			}
+			e = x.X
+		case *ast.IndexExpr:
+			if _, ok := a.info.Types[x.X].Type.Underlying().(*types.Array); !ok {
+				return false
+			}
+			e = x.X
+		default:
+			return false
+		}
+	}
+}
+
+// call returns the purity of the call c, excluding the evaluation of its
+// arguments.
+func (a *purityAnalysis) call(c *ast.CallExpr) purity {
+	fun := unparen(c.Fun)
+	if a.info.Types[fun].IsType() {
+		return pure // conversion
+	}
+	if fn, ok := fun.(*ast.SelectorExpr); ok {
+		if isPlyMethod(fn, a.info.Selections) {
+			// ply methods read their receiver, and call their callbacks
+			return maxPurity(readOnly, a.callbacks(c))
+		}
+	}
+
+	var obj types.Object
+	switch fn := fun.(type) {
+	case *ast.FuncLit:
+		return pure // the body is analyzed separately
+	case *ast.Ident:
+		obj = a.info.Uses[fn]
+	case *ast.SelectorExpr:
+		obj = a.info.Uses[fn.Sel]
+	}
+	switch obj := obj.(type) {
+	case *types.Builtin:
+		switch obj.Name() {
+		case "append":
+			// append writes to the array underlying its first argument,
+			// unless it is freshly allocated
+			switch arg := unparen(c.Args[0]).(type) {
+			case *ast.CompositeLit:
+				return pure
+			case *ast.Ident:
+				if arg.Name == "nil" {
+					return pure
+				}
+			}
+			return effectful
+		case "copy", "delete", "close", "print", "println", "recover":
+			return effectful
+		}
+		return pure
+	case *types.Ply:
+		return a.callbacks(c)
+	}
+	return a.objPurity(obj)
+}
+
+// callbacks returns the purity of calling each of the function arguments of
+// c, excluding function literals, whose bodies are analyzed separately.
+func (a *purityAnalysis) callbacks(c *ast.CallExpr) purity {
+	p := pure
+	for _, arg := range c.Args {
+		if _, isLit := unparen(arg).(*ast.FuncLit); isLit {
+			continue
+		}
+		if tv, ok := a.info.Types[arg]; ok && tv.Type != nil {
+			if _, isFunc := tv.Type.Underlying().(*types.Signature); isFunc {
+				p = maxPurity(p, a.callback(arg))
+			}
+		}
+	}
+	return p
+}
+
+// unsafeStages returns the number of stages that must be split off the front
+// of p so that pipelining the remaining stages does not change the behavior
+// of the program. It returns 0 if p can be pipelined as-is.
+func (a *purityAnalysis) unsafeStages(p *pipeline) int {
+	n := len(p.fns)
+	stages := make([]purity, n)
+	exits := make([]bool, n)
+	for i, fn := range p.fns {
+		for _, arg := range fn.Args {
+			if _, isFunc := a.info.Types[arg].Type.Underlying().(*types.Signature); isFunc {
+				stages[i] = maxPurity(stages[i], a.callback(arg))
+			} else {
+				// the argument is evaluated before any callback is called
+				stages[i] = maxPurity(stages[i], a.body(arg))
+			}
+		}
+		exits[i] = strings.Contains(p.ts[i].op, "#break") || (i == n-1 && strings.Contains(p.ts[i].cons, "return "))
+	}
+	for k := 0; k < n; k++ {
+		if safePipeline(stages[k:], exits[k:]) {
+			return k
+		}
+	}
+	return n
+}
+
+// safePipeline reports whether the stages with the given callback purities
+// can be pipelined, where exits indicates which stages may stop the pipeline
+// early.
+func safePipeline(stages []purity, exits []bool) bool {
+	lastExit := -1
+	for i, exit := range exits {
+		if exit {
+			lastExit = i
+		}
+	}
+	for i, p := range stages {
+		if p != effectful {
+			continue
+		}
+		if i < lastExit {
+			// fewer calls would be made
+			return false
+		}
+		for j, q := range stages {
+			if j != i && q != pure {
+				// calls would be reordered
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// maxPurity returns the less pure of p and q.
+func maxPurity(p, q purity) purity {
+	if p > q {
+		return p
+	}
+	return q
+}
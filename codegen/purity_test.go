@@ -0,0 +1,83 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPurity checks that chains are only fully pipelined when doing so does
+// not change how callbacks with side effects are called.
+func TestPurity(t *testing.T) {
+	tests := []struct {
+		expr  string
+		split string // the method split off the pipeline, if any
+	}{
+		// pure callbacks
+		{`xs.morph(square).take(3)`, ``},
+		{`xs.morph(func(x int) int { return x * k }).take(3)`, ``},
+		{`xs.filter(not(even)).take(3)`, ``},
+		{`xs.morph(sq).take(3)`, ``},
+
+		// effectful callbacks that are called the same number of times, in
+		// the same order
+		{`xs.filter(even).morph(counted)`, ``},
+		{`xs.morph(square).morph(counted).take(3)`, `morph`},
+		{`xs.filter(even).all(func(x int) bool { calls++; return x > 3 })`, ``},
+		{`xs.morph(counted).drop(3)`, ``},
+
+		// effectful callbacks that would be called fewer times
+		{`xs.morph(counted).take(3)`, `morph`},
+		{`xs.tee(func(x int) { ys = append(ys, x) }).any(even)`, `tee`},
+		{`xs.morph(func(x int) int { println(x); return x }).take(3)`, `morph`},
+		{`xs.morph(func(x int) int { p.n++; return x }).take(3)`, `morph`},
+		{`xs.morph(func(x int) int { ys[0] = x; return x }).take(3)`, `morph`},
+		{`xs.morph(fn).take(3)`, `morph`},
+		{`xs.morph(recursive).take(3)`, `morph`}, // conservatively
+
+		// effectful callbacks that would be reordered with read-only ones
+		{`xs.morph(counted).filter(func(x int) bool { return x > calls })`, `morph`},
+		{`xs.morph(counted).filter(func(x int) bool { return x > ys[0] })`, `morph`},
+	}
+
+	decls := `
+type pair struct{ n int }
+
+var calls int
+
+func square(x int) int { return x * x }
+
+func even(x int) bool { return x%2 == 0 }
+
+func counted(x int) int { calls++; return x }
+
+func recursive(x int) int {
+	if x == 0 {
+		return 0
+	}
+	return recursive(x - 1)
+}
+`
+	for _, test := range tests {
+		var warnings []string
+		conf := &Config{Warn: func(err error) { warnings = append(warnings, err.Error()) }}
+		if _, err := compileMain(conf, decls, `
+	xs := []int{1, 2, 3, 4, 5}
+	ys := []int{0}
+	k := 2
+	p := &pair{}
+	fn := square
+	sq := func(x int) int { return square(x) }
+	_, _, _, _, _, _ = xs, ys, k, p, fn, sq
+	_ = `+test.expr); err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if test.split == "" {
+			if len(warnings) != 0 {
+				t.Errorf("%s: expected pipeline, got %v", test.expr, warnings)
+			}
+		} else if len(warnings) != 1 || !strings.Contains(warnings[0], test.split+" not pipelined") {
+			t.Errorf("%s: expected %s to be split off, got %v", test.expr, test.split, warnings)
+		}
+	}
+}
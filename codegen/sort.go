@@ -0,0 +1,684 @@
+package codegen
+
+import (
+	"go/ast"
+	"strings"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// The sort methods are backed by a sorter type that is specialized for the
+// element type and comparison function, so that each comparison is a direct
+// (and usually inlined) call rather than a call through sort.Interface. The
+// unstable sorts use pattern-defeating quicksort, and the stable sorts use a
+// merge sort. Both are adapted from the Go standard library.
+
+// sorterTempl is the template for a sorter. Unlike other templates, it is
+// specified by genSorter, using the placeholders #sorter (the name of the
+// sorter type), #E (the type of the elements being sorted), and #less (the
+// body of a func(a, b #E) bool that reports whether a sorts before b). If the
+// sorter's fn field is set, #less may use it.
+const sorterTempl = `
+type #sorter struct {
+	fn func(#E, #E) bool
+}
+
+func (s #sorter) less(a, b #E) bool {
+	#less
+}
+
+// sort sorts data. It is not stable.
+func (s #sorter) sort(data []#E) {
+	s.pdqsort(data, 0, len(data), s.bitsLen(len(data)))
+}
+
+// stable sorts data, preserving the order of equal elements. Small blocks of
+// data are sorted with insertion sort, and then merged back and forth between
+// data and a buffer of the same length.
+func (s #sorter) stable(data []#E) {
+	const blockSize = 20
+	n := len(data)
+	for a := 0; a < n; a += blockSize {
+		b := a + blockSize
+		if b > n {
+			b = n
+		}
+		s.insertionSort(data, a, b)
+	}
+	if n <= blockSize {
+		return
+	}
+
+	src, dst := data, make([]#E, n)
+	for width := blockSize; width < n; width *= 2 {
+		for a := 0; a < n; a += 2 * width {
+			m, b := a+width, a+2*width
+			if m > n {
+				m = n
+			}
+			if b > n {
+				b = n
+			}
+			s.merge(dst[a:b], src[a:m], src[m:b])
+		}
+		src, dst = dst, src
+	}
+	if &src[0] != &data[0] {
+		copy(data, src)
+	}
+}
+
+// merge merges the sorted slices x and y into dst, which must have length
+// len(x)+len(y). Elements of x precede equal elements of y.
+func (s #sorter) merge(dst, x, y []#E) {
+	var i, j, k int
+	for i < len(x) && j < len(y) {
+		if s.less(y[j], x[i]) {
+			dst[k] = y[j]
+			j++
+		} else {
+			dst[k] = x[i]
+			i++
+		}
+		k++
+	}
+	k += copy(dst[k:], x[i:])
+	copy(dst[k:], y[j:])
+}
+
+type #sorterHint int // hint for pdqsort when choosing the pivot
+
+const (
+	#sorterUnknownHint #sorterHint = iota
+	#sorterIncreasingHint
+	#sorterDecreasingHint
+)
+
+// xorshift paper: https://www.jstatsoft.org/article/view/v008i14/xorshift.pdf
+type #sorterXorshift uint64
+
+func (r *#sorterXorshift) Next() uint64 {
+	*r ^= *r << 13
+	*r ^= *r >> 7
+	*r ^= *r << 17
+	return uint64(*r)
+}
+
+// bitsLen returns the minimum number of bits required to represent n.
+func (s #sorter) bitsLen(n int) int {
+	var l int
+	for ; n > 0; n >>= 1 {
+		l++
+	}
+	return l
+}
+
+func (s #sorter) nextPowerOfTwo(length int) uint {
+	return 1 << uint(s.bitsLen(length))
+}
+
+// insertionSort sorts data[a:b] using insertion sort.
+func (s #sorter) insertionSort(data []#E, a, b int) {
+	for i := a + 1; i < b; i++ {
+		for j := i; j > a && s.less(data[j], data[j-1]); j-- {
+			data[j], data[j-1] = data[j-1], data[j]
+		}
+	}
+}
+
+// siftDown implements the heap property on data[lo:hi].
+// first is an offset into the array where the root of the heap lies.
+func (s #sorter) siftDown(data []#E, lo, hi, first int) {
+	root := lo
+	for {
+		child := 2*root + 1
+		if child >= hi {
+			break
+		}
+		if child+1 < hi && s.less(data[first+child], data[first+child+1]) {
+			child++
+		}
+		if !s.less(data[first+root], data[first+child]) {
+			return
+		}
+		data[first+root], data[first+child] = data[first+child], data[first+root]
+		root = child
+	}
+}
+
+func (s #sorter) heapSort(data []#E, a, b int) {
+	first := a
+	lo := 0
+	hi := b - a
+
+	// Build heap with greatest element at top.
+	for i := (hi - 1) / 2; i >= 0; i-- {
+		s.siftDown(data, i, hi, first)
+	}
+
+	// Pop elements, largest first, into end of data.
+	for i := hi - 1; i >= 0; i-- {
+		data[first], data[first+i] = data[first+i], data[first]
+		s.siftDown(data, lo, i, first)
+	}
+}
+
+// pdqsort sorts data[a:b].
+// The algorithm based on pattern-defeating quicksort(pdqsort), but without the optimizations from BlockQuicksort.
+// pdqsort paper: https://arxiv.org/pdf/2106.05123.pdf
+// C++ implementation: https://github.com/orlp/pdqsort
+// Rust implementation: https://docs.rs/pdqsort/latest/pdqsort/
+// limit is the number of allowed bad (very unbalanced) pivots before falling back to heapsort.
+func (s #sorter) pdqsort(data []#E, a, b, limit int) {
+	const maxInsertion = 12
+
+	var (
+		wasBalanced    = true // whether the last partitioning was reasonably balanced
+		wasPartitioned = true // whether the slice was already partitioned
+	)
+
+	for {
+		length := b - a
+
+		if length <= maxInsertion {
+			s.insertionSort(data, a, b)
+			return
+		}
+
+		// Fall back to heapsort if too many bad choices were made.
+		if limit == 0 {
+			s.heapSort(data, a, b)
+			return
+		}
+
+		// If the last partitioning was imbalanced, we need to breaking patterns.
+		if !wasBalanced {
+			s.breakPatterns(data, a, b)
+			limit--
+		}
+
+		pivot, hint := s.choosePivot(data, a, b)
+		if hint == #sorterDecreasingHint {
+			s.reverseRange(data, a, b)
+			// The chosen pivot was pivot-a elements after the start of the array.
+			// After reversing it is pivot-a elements before the end of the array.
+			// The idea came from Rust's implementation.
+			pivot = (b - 1) - (pivot - a)
+			hint = #sorterIncreasingHint
+		}
+
+		// The slice is likely already sorted.
+		if wasBalanced && wasPartitioned && hint == #sorterIncreasingHint {
+			if s.partialInsertionSort(data, a, b) {
+				return
+			}
+		}
+
+		// Probably the slice contains many duplicate elements, partition the slice into
+		// elements equal to and elements greater than the pivot.
+		if a > 0 && !s.less(data[a-1], data[pivot]) {
+			mid := s.partitionEqual(data, a, b, pivot)
+			a = mid
+			continue
+		}
+
+		mid, alreadyPartitioned := s.partition(data, a, b, pivot)
+		wasPartitioned = alreadyPartitioned
+
+		leftLen, rightLen := mid-a, b-mid
+		balanceThreshold := length / 8
+		if leftLen < rightLen {
+			wasBalanced = leftLen >= balanceThreshold
+			s.pdqsort(data, a, mid, limit)
+			a = mid + 1
+		} else {
+			wasBalanced = rightLen >= balanceThreshold
+			s.pdqsort(data, mid+1, b, limit)
+			b = mid
+		}
+	}
+}
+
+// partition does one quicksort partition.
+// Let p = data[pivot]
+// Moves elements in data[a:b] around, so that data[i]<p and data[j]>=p for i<newpivot and j>newpivot.
+// On return, data[newpivot] = p
+func (s #sorter) partition(data []#E, a, b, pivot int) (newpivot int, alreadyPartitioned bool) {
+	data[a], data[pivot] = data[pivot], data[a]
+	i, j := a+1, b-1 // i and j are inclusive of the elements remaining to be partitioned
+
+	for i <= j && s.less(data[i], data[a]) {
+		i++
+	}
+	for i <= j && !s.less(data[j], data[a]) {
+		j--
+	}
+	if i > j {
+		data[j], data[a] = data[a], data[j]
+		return j, true
+	}
+	data[i], data[j] = data[j], data[i]
+	i++
+	j--
+
+	for {
+		for i <= j && s.less(data[i], data[a]) {
+			i++
+		}
+		for i <= j && !s.less(data[j], data[a]) {
+			j--
+		}
+		if i > j {
+			break
+		}
+		data[i], data[j] = data[j], data[i]
+		i++
+		j--
+	}
+	data[j], data[a] = data[a], data[j]
+	return j, false
+}
+
+// partitionEqual partitions data[a:b] into elements equal to data[pivot] followed by elements greater than data[pivot].
+// It assumed that data[a:b] does not contain elements smaller than the data[pivot].
+func (s #sorter) partitionEqual(data []#E, a, b, pivot int) (newpivot int) {
+	data[a], data[pivot] = data[pivot], data[a]
+	i, j := a+1, b-1 // i and j are inclusive of the elements remaining to be partitioned
+
+	for {
+		for i <= j && !s.less(data[a], data[i]) {
+			i++
+		}
+		for i <= j && s.less(data[a], data[j]) {
+			j--
+		}
+		if i > j {
+			break
+		}
+		data[i], data[j] = data[j], data[i]
+		i++
+		j--
+	}
+	return i
+}
+
+// partialInsertionSort partially sorts a slice, returns true if the slice is sorted at the end.
+func (s #sorter) partialInsertionSort(data []#E, a, b int) bool {
+	const (
+		maxSteps         = 5  // maximum number of adjacent out-of-order pairs that will get shifted
+		shortestShifting = 50 // don't shift any elements on short arrays
+	)
+	i := a + 1
+	for j := 0; j < maxSteps; j++ {
+		for i < b && !s.less(data[i], data[i-1]) {
+			i++
+		}
+
+		if i == b {
+			return true
+		}
+
+		if b-a < shortestShifting {
+			return false
+		}
+
+		data[i], data[i-1] = data[i-1], data[i]
+
+		// Shift the smaller one to the left.
+		if i-a >= 2 {
+			for j := i - 1; j >= 1; j-- {
+				if !s.less(data[j], data[j-1]) {
+					break
+				}
+				data[j], data[j-1] = data[j-1], data[j]
+			}
+		}
+		// Shift the greater one to the right.
+		if b-i >= 2 {
+			for j := i + 1; j < b; j++ {
+				if !s.less(data[j], data[j-1]) {
+					break
+				}
+				data[j], data[j-1] = data[j-1], data[j]
+			}
+		}
+	}
+	return false
+}
+
+// breakPatterns scatters some elements around in an attempt to break some patterns
+// that might cause imbalanced partitions in quicksort.
+func (s #sorter) breakPatterns(data []#E, a, b int) {
+	length := b - a
+	if length >= 8 {
+		random := #sorterXorshift(length)
+		modulus := s.nextPowerOfTwo(length)
+
+		for idx := a + (length/4)*2 - 1; idx <= a+(length/4)*2+1; idx++ {
+			other := int(uint(random.Next()) & (modulus - 1))
+			if other >= length {
+				other -= length
+			}
+			data[idx], data[a+other] = data[a+other], data[idx]
+		}
+	}
+}
+
+// choosePivot chooses a pivot in data[a:b].
+//
+// [0,8): chooses a static pivot.
+// [8,shortestNinther): uses the simple median-of-three method.
+// [shortestNinther,∞): uses the Tukey ninther method.
+func (s #sorter) choosePivot(data []#E, a, b int) (pivot int, hint #sorterHint) {
+	const (
+		shortestNinther = 50
+		maxSwaps        = 4 * 3
+	)
+
+	l := b - a
+
+	var (
+		swaps int
+		i     = a + l/4*1
+		j     = a + l/4*2
+		k     = a + l/4*3
+	)
+
+	if l >= 8 {
+		if l >= shortestNinther {
+			// Tukey ninther method, the idea came from Rust's implementation.
+			i = s.medianAdjacent(data, i, &swaps)
+			j = s.medianAdjacent(data, j, &swaps)
+			k = s.medianAdjacent(data, k, &swaps)
+		}
+		// Find the median among i, j, k and stores it into j.
+		j = s.median(data, i, j, k, &swaps)
+	}
+
+	switch swaps {
+	case 0:
+		return j, #sorterIncreasingHint
+	case maxSwaps:
+		return j, #sorterDecreasingHint
+	default:
+		return j, #sorterUnknownHint
+	}
+}
+
+// order2 returns x,y where data[x] <= data[y], where x,y=a,b or x,y=b,a.
+func (s #sorter) order2(data []#E, a, b int, swaps *int) (int, int) {
+	if s.less(data[b], data[a]) {
+		*swaps++
+		return b, a
+	}
+	return a, b
+}
+
+// median returns x where data[x] is the median of data[a],data[b],data[c], where x is a, b, or c.
+func (s #sorter) median(data []#E, a, b, c int, swaps *int) int {
+	a, b = s.order2(data, a, b, swaps)
+	b, c = s.order2(data, b, c, swaps)
+	a, b = s.order2(data, a, b, swaps)
+	return b
+}
+
+// medianAdjacent finds the median of data[a - 1], data[a], data[a + 1] and stores the index into a.
+func (s #sorter) medianAdjacent(data []#E, a int, swaps *int) int {
+	return s.median(data, a-1, a, a+1, swaps)
+}
+
+func (s #sorter) reverseRange(data []#E, a, b int) {
+	i := a
+	j := b - 1
+	for i < j {
+		data[i], data[j] = data[j], data[i]
+		i++
+		j--
+	}
+}
+`
+
+// genSorter returns the code for a sorter type named sorter that sorts
+// elements of type elem according to the function body less.
+func genSorter(sorter, elem, less string) string {
+	code := strings.Replace(sorterTempl, "#sorter", sorter, -1)
+	code = strings.Replace(code, "#E", elem, -1)
+	return strings.Replace(code, "#less", less, -1)
+}
+
+// Comparison function bodies for sorters. NaNs sort before all other values,
+// as in the sort package.
+const (
+	lessAsc  = `return a < b || (a != a && b == b)`
+	lessDesc = `return b < a || (a == a && b != b)`
+	lessFn   = `return s.fn(a, b)`
+	lessKey  = `return a.key < b.key || (a.key != a.key && b.key == b.key)`
+	lessEnt  = `return a.Key < b.Key || (a.Key != a.Key && b.Key == b.Key)`
+)
+
+const sortTempl = `
+type #name []#T
+
+func (xs #name) sort() []#T {
+	s := make([]#T, len(xs))
+	copy(s, xs)
+	#namesorter{}.sort(s)
+	return s
+}
+`
+
+const sortFnTempl = `
+type #name []#T
+
+func (xs #name) sort(less func(#T, #T) bool) []#T {
+	s := make([]#T, len(xs))
+	copy(s, xs)
+	#namesorter{less}.sort(s)
+	return s
+}
+`
+
+func sortGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	// determine arg types
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	if len(args) == 0 {
+		name, code, r = genMethod(n, sortTempl, "sort_slice", T)
+		code += genSorter(name+"sorter", T.String(), lessAsc)
+	} else if len(args) == 1 {
+		name, code, r = genMethod(n, sortFnTempl, "sortFn_slice", T)
+		code += genSorter(name+"sorter", T.String(), lessFn)
+	}
+	return
+}
+
+const sortByTempl = `
+type #name []#T
+
+type #namekeyed struct {
+	key  #U
+	elem #T
+}
+
+func (xs #name) sortBy(key func(#T) #U) []#T {
+	keyed := make([]#namekeyed, len(xs))
+	for i, x := range xs {
+		keyed[i] = #namekeyed{key(x), x}
+	}
+	#namesorter{}.stable(keyed)
+	s := make([]#T, len(xs))
+	for i := range keyed {
+		s[i] = keyed[i].elem
+	}
+	return s
+}
+`
+
+func sortByGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	// determine arg types
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	U := exprTypes[args[0]].Type.Underlying().(*types.Signature).Results().At(0).Type()
+	name, code, r = genMethod(n, sortByTempl, "sortBy_slice", T, U)
+	code += genSorter(name+"sorter", name+"keyed", lessKey)
+	return
+}
+
+const sortDescTempl = `
+type #name []#T
+
+func (xs #name) sortDesc() []#T {
+	s := make([]#T, len(xs))
+	copy(s, xs)
+	#namesorter{}.sort(s)
+	return s
+}
+`
+
+func sortDescGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	name, code, r = genMethod(n, sortDescTempl, "sortDesc_slice", T)
+	code += genSorter(name+"sorter", T.String(), lessDesc)
+	return
+}
+
+const sortStableTempl = `
+type #name []#T
+
+func (xs #name) sortStable(less func(#T, #T) bool) []#T {
+	s := make([]#T, len(xs))
+	copy(s, xs)
+	#namesorter{less}.stable(s)
+	return s
+}
+`
+
+func sortStableGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+	name, code, r = genMethod(n, sortStableTempl, "sortStable_slice", T)
+	code += genSorter(name+"sorter", T.String(), lessFn)
+	return
+}
+
+const sortedEntriesTempl = `
+type #name map[#T]#U
+
+func (m #name) sortedEntries() []struct{Key #T; Val #U} {
+	es := make([]struct{Key #T; Val #U}, 0, len(m))
+	for k, e := range m {
+		es = append(es, struct{Key #T; Val #U}{k, e})
+	}
+	#namesorter{}.sort(es)
+	return es
+}
+`
+
+func sortedEntriesGen(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	mt := exprTypes[fn.X].Type.Underlying().(*types.Map)
+	name, code, r = genMethod(n, sortedEntriesTempl, "sortedEntries_map", mt.Key(), mt.Elem())
+	code += genSorter(name+"sorter", "struct{Key "+mt.Key().String()+"; Val "+mt.Elem().String()+"}", lessEnt)
+	return
+}
+
+// The sorted-slice methods assume that their receiver is already sorted. Like
+// sort, they take an optional less function. Their templates use the
+// placeholders #lessParam (the less parameter, if any) and #less (the
+// function that compares two elements); genSortedMethod specifies them before
+// the rest of the template. Without a less function, #less is a generated
+// function that orders NaNs before all other values, as in the sorters.
+
+const binarySearchTempl = `
+type #name []#T
+
+func (xs #name) binarySearch(e #T#lessParam) (int, bool) {
+	i, j := 0, len(xs)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if #less(xs[h], e) {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return i, i < len(xs) && !#less(e, xs[i])
+}
+`
+
+const dedupSortedTempl = `
+type #name []#T
+
+func (xs #name) dedupSorted(#lessParam) []#T {
+	if len(xs) == 0 {
+		return nil
+	}
+	dedup := []#T{xs[0]}
+	for _, x := range xs[1:] {
+		if #less(dedup[len(dedup)-1], x) {
+			dedup = append(dedup, x)
+		}
+	}
+	return dedup
+}
+`
+
+const insertSortedTempl = `
+type #name []#T
+
+func (xs #name) insertSorted(e #T#lessParam) []#T {
+	// insert after any elements equal to e
+	i, j := 0, len(xs)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if !#less(e, xs[h]) {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	s := make([]#T, len(xs)+1)
+	copy(s, xs[:i])
+	s[i] = e
+	copy(s[i+1:], xs[i:])
+	return s
+}
+`
+
+const mergeSortedTempl = `
+type #name []#T
+
+func (xs #name) mergeSorted(ys []#T#lessParam) []#T {
+	merged := make([]#T, 0, len(xs)+len(ys))
+	i, j := 0, 0
+	for i < len(xs) && j < len(ys) {
+		// prefer xs when elements are equal
+		if #less(ys[j], xs[i]) {
+			merged = append(merged, ys[j])
+			j++
+		} else {
+			merged = append(merged, xs[i])
+			i++
+		}
+	}
+	merged = append(merged, xs[i:]...)
+	return append(merged, ys[j:]...)
+}
+`
+
+// for sorted-slice methods that take nreq required arguments, followed by an
+// optional less function
+func genSortedMethod(templ, methodname string, nreq int) func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+	return func(n *namer, fn *ast.SelectorExpr, args []ast.Expr, exprTypes map[ast.Expr]types.TypeAndValue) (name, code string, r rewriter) {
+		T := exprTypes[fn.X].Type.Underlying().(*types.Slice).Elem()
+		if len(args) == nreq {
+			code = strings.Replace(templ, "#lessParam", "", -1)
+			code = strings.Replace(code, "#less", "#nameless", -1)
+			code += "\nfunc #nameless(a, b #T) bool {\n\t" + lessAsc + "\n}\n"
+			return genMethod(n, code, methodname+"_slice", T)
+		}
+		lessParam := "less func(#T, #T) bool"
+		if nreq > 0 {
+			lessParam = ", " + lessParam
+		}
+		code = strings.Replace(templ, "#lessParam", lessParam, -1)
+		code = strings.Replace(code, "#less", "less", -1)
+		return genMethod(n, code, methodname+"Fn_slice", T)
+	}
+}
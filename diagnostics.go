@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"go/scanner"
+	"go/token"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/lukechampine/ply/codegen"
+	"github.com/lukechampine/ply/types"
+)
+
+// A diagnostic is the machine-readable form of a compilation error, as
+// emitted by the -json flag.
+type diagnostic struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Msg    string `json:"msg"`
+	Soft   bool   `json:"soft"` // the error does not prevent compilation
+	Kind   string `json:"kind"` // "parse", "type", "codegen", "warning", or "error"
+}
+
+// diagnostics converts err to a list of diagnostics. Lists of errors are
+// flattened.
+func diagnostics(err error) []diagnostic {
+	var diags []diagnostic
+	withPos := func(pos token.Position, msg string, soft bool, kind string) {
+		diags = append(diags, diagnostic{
+			File:   pos.Filename,
+			Line:   pos.Line,
+			Column: pos.Column,
+			Msg:    msg,
+			Soft:   soft,
+			Kind:   kind,
+		})
+	}
+	switch err := err.(type) {
+	case codegen.ErrorList:
+		for _, e := range err {
+			diags = append(diags, diagnostics(e)...)
+		}
+	case scanner.ErrorList:
+		for _, e := range err {
+			withPos(e.Pos, e.Msg, false, "parse")
+		}
+	case scanner.Error:
+		withPos(err.Pos, err.Msg, false, "parse")
+	case *scanner.Error:
+		withPos(err.Pos, err.Msg, false, "parse")
+	case types.Error:
+		withPos(err.Fset.Position(err.Pos), err.Msg, err.Soft, "type")
+	case codegen.GenError:
+		withPos(err.Fset.Position(err.Pos), err.Msg, false, "codegen")
+	case codegen.Warning:
+		withPos(err.Fset.Position(err.Pos), err.Msg, true, "warning")
+	default:
+		diags = append(diags, diagnostic{Msg: err.Error(), Kind: "error"})
+	}
+	return diags
+}
+
+// reportErrors prints err, either as text on stderr or as a stream of JSON
+// diagnostics on stdout, and exits.
+func reportErrors(err error, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, d := range diagnostics(err) {
+			enc.Encode(d)
+		}
+	} else if list, ok := err.(codegen.ErrorList); ok {
+		for _, e := range list {
+			log.Println(e)
+		}
+	} else {
+		log.Println(err)
+	}
+	os.Exit(1)
+}
+
+// warnings serializes the output of reportWarning, since packages may be
+// compiled concurrently.
+var warnings sync.Mutex
+
+// reportWarning prints w, either as text on stderr or as a JSON diagnostic on
+// stdout.
+func reportWarning(w error, asJSON bool) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, d := range diagnostics(w) {
+			enc.Encode(d)
+		}
+	} else {
+		log.Println(w)
+	}
+}
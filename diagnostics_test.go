@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"testing"
+
+	"github.com/lukechampine/ply/codegen"
+	"github.com/lukechampine/ply/types"
+)
+
+func TestDiagnostics(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("foo.ply", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	pos := f.Pos(23) // 3:4
+
+	err := codegen.ErrorList{
+		scanner.Error{Pos: token.Position{Filename: "foo.ply", Line: 1, Column: 2}, Msg: "parse"},
+		types.Error{Fset: fset, Pos: pos, Msg: "hard"},
+		types.Error{Fset: fset, Pos: pos, Msg: "soft", Soft: true},
+		codegen.GenError{Fset: fset, Pos: pos, Msg: "codegen"},
+		codegen.Warning{Fset: fset, Pos: pos, Msg: "warning"},
+		errors.New("other"),
+	}
+	exp := []diagnostic{
+		{File: "foo.ply", Line: 1, Column: 2, Msg: "parse", Kind: "parse"},
+		{File: "foo.ply", Line: 3, Column: 4, Msg: "hard", Kind: "type"},
+		{File: "foo.ply", Line: 3, Column: 4, Msg: "soft", Soft: true, Kind: "type"},
+		{File: "foo.ply", Line: 3, Column: 4, Msg: "codegen", Kind: "codegen"},
+		{File: "foo.ply", Line: 3, Column: 4, Msg: "warning", Soft: true, Kind: "warning"},
+		{Msg: "other", Kind: "error"},
+	}
+	if diags := diagnostics(err); !reflect.DeepEqual(diags, exp) {
+		t.Errorf("expected %+v, got %+v", exp, diags)
+	}
+}
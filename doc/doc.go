@@ -27,10 +27,50 @@ type W int
 // underlying type is []T.
 type SliceT int
 
+// SliceSliceT is a slice whose element type is []T. This includes named
+// types whose underlying type is [][]T, or whose element type is a named type
+// with underlying type []T.
+type SliceSliceT int
+
 // MapTU is a map with element type T and key type U. This includes named
 // types whose underlying type is map[T]U.
 type MapTU int
 
+// MapTS is a set: a map with key type T and element type struct{} or bool.
+// This includes named types whose underlying type is map[T]struct{} or
+// map[T]bool. When S is bool, only the keys mapped to true are members of the
+// set; the sets returned by set methods map each member to true.
+type MapTS int
+
+// SliceEntryTU is a slice of map entries, []struct{Key T; Val U}, as
+// returned by Entries and SortedEntries.
+type SliceEntryTU int
+
+// Difference returns a new set containing the members of m that are not
+// members of other.
+func (m MapTS) Difference(other MapTS) MapTS
+
+// Equal returns true if m and other have the same members.
+func (m MapTS) Equal(other MapTS) bool
+
+// Intersect returns a new set containing the members of m that are also
+// members of other.
+func (m MapTS) Intersect(other MapTS) MapTS
+
+// IsSubset returns true if every member of m is a member of other.
+func (m MapTS) IsSubset(other MapTS) bool
+
+// IsSuperset returns true if every member of other is a member of m.
+func (m MapTS) IsSuperset(other MapTS) bool
+
+// SymmetricDifference returns a new set containing the members of m or other
+// that are not members of both.
+func (m MapTS) SymmetricDifference(other MapTS) MapTS
+
+// Union returns a new set containing the members of m and the members of
+// other.
+func (m MapTS) Union(other MapTS) MapTS
+
 // Contains returns true if m contains e. It is shorthand for:
 //
 //    _, ok := m[e]
@@ -41,32 +81,124 @@ func (m MapTU) Contains(e T) bool
 // specified.
 func (m MapTU) Elems() []U
 
+// Entries returns the key/value pairs of m. The order of the entries is not
+// specified; use SortedEntries if a deterministic order is required. The
+// entries can be converted back into a map with FromEntries.
+func (m MapTU) Entries() SliceEntryTU
+
 // Filter returns a new map containing only the key/value pairs of m that
 // satisfy pred.
 func (m MapTU) Filter(pred func(T, U) bool) MapTU
 
+// Invert returns a new map in which each element of m is mapped to its key.
+// U must be a valid map key type, i.e. a comparable type. If several keys
+// share the same element, it is not specified which of them is kept.
+func (m MapTU) Invert() map[U]T
+
 // Keys returns the keys of m. The order of the keys is not specified.
 func (m MapTU) Keys() []T
 
+// MapKeys returns a new map in which the result of applying fn to each key of
+// m is mapped to the key's element. V must be a valid map key type, i.e. a
+// comparable type. If fn maps several keys to the same value, it is not
+// specified which element is kept.
+func (m MapTU) MapKeys(fn func(T) V) map[V]U
+
+// MapValues returns a new map in which each key of m is mapped to the result
+// of applying fn to its element. Unlike Morph, fn does not need to return the
+// key.
+func (m MapTU) MapValues(fn func(U) V) map[T]V
+
 // Morph returns a new map containing the result of applying fn to each
 // key/value pair of m. V must be a valid map key type, i.e. a comparable
 // type.
 func (m MapTU) Morph(fn func(T, U) (V, W)) map[V]W
 
+// SortedEntries returns the key/value pairs of m, sorted by key. T must be an
+// ordered type; NaN keys are ordered before other keys, as in Sort.
+func (m MapTU) SortedEntries() SliceEntryTU
+
 // All returns true if all elements of s satisfy pred. It returns as soon as
 // it encounters an element that does not satisfy pred.
 func (s SliceT) All(pred func(T) bool) bool
 
+// Alli is like All, but pred also receives the index of each element.
+func (s SliceT) Alli(pred func(int, T) bool) bool
+
 // Any returns true if any elements of s satisfy pred. It returns as soon as
 // it encounters an element that satisfies pred.
 func (s SliceT) Any(pred func(T) bool) bool
 
+// Anyi is like Any, but pred also receives the index of each element.
+func (s SliceT) Anyi(pred func(int, T) bool) bool
+
+// Argmax returns the index of the greatest element of s, or -1 if s is
+// empty. If there are multiple greatest elements, the index of the first is
+// returned. T must be an ordered type, and elements are compared as in Max.
+func (s SliceT) Argmax() int
+
+// Argmin returns the index of the least element of s, or -1 if s is empty.
+// If there are multiple least elements, the index of the first is returned.
+// T must be an ordered type, and elements are compared as in Min.
+func (s SliceT) Argmin() int
+
+// BinarySearch searches for e in s, which must be sorted according to the
+// less function. It returns the index of the first element of s that is not
+// less than e, and whether that element is equal to e. In other words, if e
+// is not present, the index is where it would be inserted. If less is not
+// supplied, T must be an ordered type, and the same less function as Sort is
+// used.
+func (s SliceT) BinarySearch(e T, less func(T, T) bool) (int, bool)
+
+// Chunk splits s into consecutive sub-slices of length n; the last chunk may
+// be shorter. The chunks share the same underlying memory as s, but their
+// capacity is limited so that appending to one does not overwrite the next.
+// Chunk panics if n is not positive.
+//
+// Chunk can be pipelined only as the first method in a chain, so
+//
+//    xs.chunk(3).morph(sum)
+//
+// does not allocate the slice of chunks.
+func (s SliceT) Chunk(n int) [][]T
+
 // Contains returns true if s contains e. T must be a comparable type; see
 // https://golang.org/ref/spec#Comparison_operators
 //
 // As a special case, T may be a slice, map, or function if e is nil.
 func (s SliceT) Contains(e T) bool
 
+// Count returns the number of elements of s that satisfy pred.
+func (s SliceT) Count(pred func(T) bool) int
+
+// CountBy returns a map in which each key computed by key is mapped to the
+// number of elements of s that yielded it. U must be a valid map key type,
+// i.e. a comparable type.
+//
+// CountBy can be pipelined, so
+//
+//    xs.filter(even).countBy(digits)
+//
+// does not allocate an intermediate slice.
+func (s SliceT) CountBy(key func(T) U) map[U]int
+
+// DedupSorted returns a new slice containing the unique elements of s, which
+// must be sorted according to the less function. Two elements are considered
+// equal if neither is less than the other. If less is not supplied, T must be
+// an ordered type, and the same less function as Sort is used.
+//
+// Unlike Uniq, DedupSorted only compares adjacent elements, so it does not
+// allocate a set. DedupSorted can be pipelined, so
+//
+//    xs.filter(even).dedupSorted()
+//
+// does not allocate an intermediate slice.
+func (s SliceT) DedupSorted(less func(T, T) bool) SliceT
+
+// Difference returns a new slice containing the unique elements of s that do
+// not appear in other, in their original order. T must be a comparable type.
+func (s SliceT) Difference(other SliceT) SliceT
+
 // Drop returns a slice omitting the first n elements of s. The returned slice
 // shares the same underlying memory as s. If n is greater than len(s), the
 // latter is used. In other words, Drop is short for:
@@ -83,10 +215,49 @@ func (s SliceT) Drop(n int) SliceT
 // that does not satisfy pred.
 func (s SliceT) DropWhile(pred func(T) bool) SliceT
 
+// Equal returns true if s and other contain the same elements, ignoring order
+// and duplicates. T must be a comparable type.
+func (s SliceT) Equal(other SliceT) bool
+
 // Filter returns a new slice containing only the elements of s that satisfy
 // pred.
 func (s SliceT) Filter(pred func(T) bool) SliceT
 
+// Filteri is like Filter, but pred also receives the index of each element.
+func (s SliceT) Filteri(pred func(int, T) bool) SliceT
+
+// Find returns the first element of s that satisfies pred. If no element
+// satisfies pred, Find returns the zero value of T and false. Find returns as
+// soon as it encounters an element that satisfies pred.
+//
+// When pipelined, as in
+//
+//    xs.morph(parse).find(valid)
+//
+// no further elements of xs are morphed once a valid element is found.
+func (s SliceT) Find(pred func(T) bool) (T, bool)
+
+// FindIndex returns the index of the first element of s that satisfies pred,
+// or -1 if no element satisfies pred. Like Find, it returns as soon as it
+// encounters an element that satisfies pred.
+func (s SliceT) FindIndex(pred func(T) bool) int
+
+// FlatMorph returns a new slice containing the concatenation of the slices
+// returned by fn for each element of s. It is equivalent to, but more
+// efficient than:
+//
+//    s.morph(fn).flatten()
+//
+// FlatMorph can be pipelined, so
+//
+//    xs.flatMorph(divisors).filter(even)
+//
+// does not allocate an intermediate slice.
+//
+// The length of the result cannot be known in advance, so it is grown as
+// needed. If hint is supplied, it is used as the initial capacity instead.
+func (s SliceT) FlatMorph(fn func(T) []U, hint int) []U
+
 // Fold returns the result of repeatedly applying fn to an initial
 // "accumulator" value and each element of s. If no initial value is provided,
 // Fold uses the first element of s. Note that this implies that T and U are
@@ -104,23 +275,183 @@ func (s SliceT) Filter(pred func(T) bool) SliceT
 // yield 1 - (2 - (3 - 4)) == -2.
 func (s SliceT) Fold(fn func(U, T) U, acc U) U
 
+// Foldi is like Fold, but fn also receives the index of each element. Unlike
+// Fold, the initial value is mandatory.
+func (s SliceT) Foldi(fn func(U, int, T) U, acc U) U
+
 // Foreach calls fn on each element of s.
 func (s SliceT) Foreach(fn func(T))
 
+// Foreachi is like Foreach, but fn also receives the index of each element.
+func (s SliceT) Foreachi(fn func(int, T))
+
+// GroupBy returns a map in which each key computed by key is mapped to the
+// elements of s that yielded it, in their original order. U must be a valid
+// map key type, i.e. a comparable type. key is called once per element.
+func (s SliceT) GroupBy(key func(T) U) map[U][]T
+
+// IndexOf returns the index of the first occurrence of e in s, or -1 if s
+// does not contain e. The same restrictions on T apply as in Contains.
+func (s SliceT) IndexOf(e T) int
+
+// InsertSorted returns a new slice containing the elements of s with e
+// inserted in sorted order, after any elements equal to e. s must be sorted
+// according to the less function. If less is not supplied, T must be an
+// ordered type, and the same less function as Sort is used.
+func (s SliceT) InsertSorted(e T, less func(T, T) bool) SliceT
+
+// Intersect returns a new slice containing the unique elements of s that also
+// appear in other, in their original order. T must be a comparable type.
+func (s SliceT) Intersect(other SliceT) SliceT
+
+// IsSubset returns true if every element of s appears in other. T must be a
+// comparable type.
+func (s SliceT) IsSubset(other SliceT) bool
+
+// IsSuperset returns true if every element of other appears in s. T must be a
+// comparable type.
+func (s SliceT) IsSuperset(other SliceT) bool
+
+// LastIndexOf returns the index of the last occurrence of e in s, or -1 if s
+// does not contain e. The same restrictions on T apply as in Contains.
+func (s SliceT) LastIndexOf(e T) int
+
+// Max returns the greatest element of s. If s is empty, Max returns the zero
+// value of T and false. T must be an ordered type; see
+// https://golang.org/ref/spec#Comparison_operators
+//
+// NaNs are ordered before all other values, as in Sort. That is, Max only
+// returns NaN if every element of s is NaN.
+//
+// Max can be pipelined, so
+//
+//    xs.filter(even).max()
+//
+// does not allocate an intermediate slice.
+func (s SliceT) Max() (T, bool)
+
+// MaxBy returns the element of s with the greatest key, as computed by key.
+// key is called exactly once per element. If multiple elements have the
+// greatest key, the first is returned. If s is empty, MaxBy returns the zero
+// value of T and false. U must be an ordered type; keys are compared as in
+// Max.
+func (s SliceT) MaxBy(key func(T) U) (T, bool)
+
+// Mean returns the arithmetic mean of the elements of s. If s is empty, Mean
+// returns 0 and false. T must be an integer or floating-point type; the
+// elements are converted to float64 before being summed.
+func (s SliceT) Mean() (float64, bool)
+
+// MergeSorted returns a new slice containing the elements of s and other in
+// sorted order. Both s and other must be sorted according to the less
+// function. The merge is stable: when elements are equal, those from s come
+// first. If less is not supplied, T must be an ordered type, and the same less
+// function as Sort is used.
+func (s SliceT) MergeSorted(other SliceT, less func(T, T) bool) SliceT
+
+// Min returns the least element of s. If s is empty, Min returns the zero
+// value of T and false. T must be an ordered type; see
+// https://golang.org/ref/spec#Comparison_operators
+//
+// NaNs are ordered before all other values, as in Sort. That is, Min returns
+// NaN if any element of s is NaN.
+func (s SliceT) Min() (T, bool)
+
+// MinBy returns the element of s with the least key, as computed by key. key
+// is called exactly once per element. If multiple elements have the least
+// key, the first is returned. If s is empty, MinBy returns the zero value of
+// T and false. U must be an ordered type; keys are compared as in Min.
+func (s SliceT) MinBy(key func(T) U) (T, bool)
+
 // Morph returns a new slice containing the result of applying fn to each
 // element of s.
 func (s SliceT) Morph(fn func(T) U) []U
 
+// Morphi is like Morph, but fn also receives the index of each element.
+//
+// Morphi, Filteri, Foldi, Foreachi, Alli, and Anyi can be pipelined, but
+// only after methods that do not move elements to a different index: Morph,
+// Morphi, Take, TakeWhile, and Tee. For example, in
+//
+//    xs.morph(square).filteri(evenIndex)
+//
+// filteri receives the index of each element in xs, which is also its index
+// in the morphed slice. In contrast, xs.filter(odd).filteri(evenIndex)
+// allocates the filtered slice, since the indices passed to filteri are not
+// the indices of xs.
+func (s SliceT) Morphi(fn func(int, T) U) []U
+
+// Pairs returns each pair of adjacent elements of s, i.e. [s[0], s[1]],
+// [s[1], s[2]], and so on. If len(s) < 2, Pairs returns nil.
+//
+// Like Window, Pairs can be pipelined only as the first method in a chain.
+func (s SliceT) Pairs() [][2]T
+
+// Partition returns two new slices: the elements of s that satisfy pred, and
+// the elements that do not. The order of elements is preserved in both.
+// Because it has multiple results, Partition cannot be followed by another
+// method in a chain; however, it can be the last method of a pipeline.
+func (s SliceT) Partition(pred func(T) bool) (yes, no []T)
+
+// Product returns the product of the elements of s, or 1 if s is empty. T
+// must be a numeric type.
+func (s SliceT) Product() T
+
 // Reverse returns a new slice containing the elements of s in reverse order.
+//
+// Reverse can only be pipelined if it is the first or last method in a
+// chain. In the former case, the pipeline iterates through s backwards; in
+// the latter, the result is reversed in-place.
 func (s SliceT) Reverse() SliceT
 
 // Sort returns a new slice containing the elements of s in sorted order,
-// according to the less function. If less is not supplied, s must either be
-// an ordered type or implement sort.Interface. In the former case, the <
-// operator is used as the less function. See
+// according to the less function. If less is not supplied, T must be an
+// ordered type, and the < operator is used as the less function, with NaNs
+// ordered before other values. See
 // https://golang.org/ref/spec#Comparison_operators
+//
+// Sort is not stable: the order of equal elements is not preserved. Use
+// SortStable if this is required.
+//
+// Sort does not use the sort package. Instead, a pattern-defeating quicksort
+// is generated for T, so comparisons do not incur the overhead of calling
+// through an interface. If less is supplied, it is called directly.
 func (s SliceT) Sort(less func(T, T) bool) SliceT
 
+// SortBy returns a new slice containing the elements of s, sorted according
+// to the value of key for each element. U must be an ordered type. The sort
+// is stable, so elements with equal keys retain their original order.
+//
+// key is called exactly once per element, so SortBy may be much faster than
+// Sort when computing the key is expensive. However, it allocates a
+// temporary slice to hold the keys.
+func (s SliceT) SortBy(key func(T) U) SliceT
+
+// SortDesc returns a new slice containing the elements of s in descending
+// order, as determined by the > operator. T must be an ordered type. Like
+// Sort, SortDesc is not stable.
+func (s SliceT) SortDesc() SliceT
+
+// SortStable returns a new slice containing the elements of s in sorted
+// order, according to the less function. Unlike Sort, SortStable preserves
+// the original order of equal elements. It is implemented with a merge sort
+// specialized for T, and allocates a temporary buffer the size of s.
+func (s SliceT) SortStable(less func(T, T) bool) SliceT
+
+// Sum returns the sum of the elements of s, or 0 if s is empty. T must be a
+// numeric type. Sum is shorthand for:
+//
+//    s.fold(func(x, y T) T { return x + y }, 0)
+//
+// but is more efficient. Like Fold, Sum can be pipelined.
+func (s SliceT) Sum() T
+
+// SymmetricDifference returns a new slice containing the unique elements of s
+// that do not appear in other, followed by the unique elements of other that
+// do not appear in s, each in their original order. T must be a comparable
+// type.
+func (s SliceT) SymmetricDifference(other SliceT) SliceT
+
 // Take returns a slice containing the first n elements of s. The returned
 // slice shares the same underlying memory as s. If n is greater than len(s),
 // the latter is used. In other words, Take is short for:
@@ -149,10 +480,58 @@ func (s SliceT) ToMap(fn func(T) U) map[T]U
 // the empty struct.
 func (s SliceT) ToSet() map[T]struct{}
 
+// Union returns a new slice containing the unique elements of s followed by
+// the unique elements of other that do not appear in s, each in their
+// original order. In other words, Union is short for:
+//
+//    append(s, other...).uniq()
+//
+// except that it does not modify the underlying memory of s. T must be a
+// comparable type.
+func (s SliceT) Union(other SliceT) SliceT
+
 // Uniq returns a new slice containing the unique elements of s. The order of
 // elements is preserved.
 func (s SliceT) Uniq() SliceT
 
+// Window returns each sub-slice of s with length n, in order, i.e. s[0:n],
+// s[1:n+1], and so on. The windows share the same underlying memory as s,
+// and overlap one another. If len(s) < n, Window returns nil. Window panics
+// if n is not positive.
+//
+// Window can be pipelined only as the first method in a chain, so
+//
+//    xs.window(3).morph(avg)
+//
+// computes a moving average without allocating the slice of windows.
+func (s SliceT) Window(n int) [][]T
+
+// Flatten returns a new slice containing the concatenation of the elements
+// of s. In other words, Flatten is short for:
+//
+//    s.fold(func(acc, x []T) []T { return append(acc, x...) }, nil)
+//
+// except that Flatten allocates the result only once. Flatten can be
+// pipelined, so
+//
+//    xs.morph(divisors).flatten().filter(even)
+//
+// does not allocate any intermediate slices. When pipelined, the length of
+// the result cannot be known in advance, so it is grown as needed. If hint is
+// supplied, it is used as the initial capacity instead.
+func (s SliceSliceT) Flatten(hint int) []T
+
+// Compose returns the composition of f and g, i.e. a function that calls f on
+// the result of g. g may have any number of arguments, but must have a single
+// return value, which must be the type of f's only argument. For example:
+//
+//    quote := compose(strconv.Quote, strconv.Itoa)
+//    quote(7) // == `"7"`
+//
+// If f and g are both package-level functions, Compose is replaced with a
+// top-level function that calls them directly, and does not allocate.
+func Compose(f func(U) V, g func(T) U) func(T) V
+
 // Enum enumerates the range [x,y) using step s, which may be negative. T must
 // be an integer type, which includes byte and rune. Only one argument is
 // mandatory:
@@ -164,6 +543,12 @@ func (s SliceT) Uniq() SliceT
 // unreachable, i.e. if s == 0 || (x > y && s > 0) || (x < y && s < 0).
 func Enum(x, y, s T) []T
 
+// FromEntries returns a map in which the Key of each entry of es is mapped to
+// its Val. It is the inverse of Entries. If several entries have the same Key,
+// later entries overwrite earlier ones. The elements of es may also be of a
+// named type whose underlying type is struct{Key T; Val U}.
+func FromEntries(es SliceEntryTU) map[T]U
+
 // Max returns the larger of x or y, as determined by the > operator. T must
 // be an ordered type; see https://golang.org/ref/spec#Comparison_operators
 //
@@ -195,6 +580,9 @@ func Min(x, y T) T
 // return value. For example, given an "even" function, not(even) returns an
 // "odd" function. fn may have any number of arguments, but must have a single
 // boolean return value.
+//
+// If fn is a package-level function, Not is replaced with a top-level function
+// that calls fn directly, rather than a closure that wraps it.
 func Not(fn T) T
 
 // Zip calls fn on each successive pair of values in xs and ys and appends the
@@ -0,0 +1,141 @@
+// Package format implements standard formatting of Ply source.
+//
+// Ply source is formatted exactly as gofmt would format it, with one
+// addition: in a chain of ply method calls, each call after the first begins
+// on a new line if the chain has at least MinChain calls, and directly
+// follows the previous call otherwise. For example:
+//
+//    b := xs.filter(gt3).morph(even).fold(and)
+//    ys := xs.filter(gt3).
+//        morph(even)
+//
+// is formatted as:
+//
+//    b := xs.filter(gt3).
+//        morph(even).
+//        fold(and)
+//    ys := xs.filter(gt3).morph(even)
+//
+// The first call of a chain is left where it is, as is any call preceded by
+// a comment.
+package format
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"sort"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// MinChain is the minimum number of calls in a chain of ply methods for the
+// chain to be split across multiple lines. Shorter chains are joined onto a
+// single line.
+const MinChain = 3
+
+// Source formats src in the canonical Ply style and returns the result or an
+// (I/O or syntax) error. src is expected to be a syntactically correct .ply
+// source file. If simplify is true, Source also applies the simplifications
+// described by Simplify.
+func Source(src []byte, simplify bool) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if simplify {
+		Simplify(f)
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return formatChains(buf.Bytes())
+}
+
+// chainLinks returns the calls comprising the chain of ply method calls that
+// ends with c, in reverse order. The first call of the chain is not a link,
+// since it directly follows the chain's receiver.
+func chainLinks(c *ast.CallExpr) (links []*ast.SelectorExpr) {
+	for {
+		sel, ok := c.Fun.(*ast.SelectorExpr)
+		if !ok || !types.IsPlyMethod(sel.Sel.Name) {
+			return nil
+		}
+		next, ok := sel.X.(*ast.CallExpr)
+		if !ok {
+			return links
+		}
+		if s, ok := next.Fun.(*ast.SelectorExpr); !ok || !types.IsPlyMethod(s.Sel.Name) {
+			return links
+		}
+		links = append(links, sel)
+		c = next
+	}
+}
+
+// formatChains inserts a newline before each link of every chain of at least
+// MinChain ply method calls in src, which must be gofmt'd, and removes the
+// newline before each link of every shorter chain. It then formats the
+// result.
+func formatChains(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(f.Pos())
+
+	// an edit replaces the whitespace between the dot preceding a link and
+	// the link's method name; edits are keyed by the offset of the former
+	type edit struct {
+		end  int
+		text string
+	}
+	edits := make(map[int]edit)
+	inChain := make(map[*ast.CallExpr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		c, ok := n.(*ast.CallExpr)
+		if !ok || inChain[c] {
+			return true
+		}
+		links := chainLinks(c)
+		for _, sel := range links {
+			inChain[sel.X.(*ast.CallExpr)] = true
+		}
+		split := len(links)+1 >= MinChain
+		for _, sel := range links {
+			start, end := tf.Offset(sel.X.End())+1, tf.Offset(sel.Sel.Pos())
+			if src[start-1] != '.' || len(bytes.TrimSpace(src[start:end])) != 0 {
+				// a comment precedes the link
+				continue
+			}
+			onNewLine := tf.Line(sel.X.End()) != tf.Line(sel.Sel.Pos())
+			if split && !onNewLine {
+				edits[start] = edit{end, "\n"}
+			} else if !split && onNewLine {
+				edits[start] = edit{end, ""}
+			}
+		}
+		return true
+	})
+	if len(edits) == 0 {
+		return src, nil
+	}
+
+	// apply edits, beginning with the last offset so that earlier offsets
+	// remain valid
+	offsets := make([]int, 0, len(edits))
+	for off := range edits {
+		offsets = append(offsets, off)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	for _, off := range offsets {
+		e := edits[off]
+		src = append(src[:off], append([]byte(e.text), src[e.end:]...)...)
+	}
+	return format.Source(src)
+}
@@ -0,0 +1,75 @@
+package format
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		simplify bool
+		in, out  string
+	}{
+		// short chains are joined
+		{false, `xs.filter(p).morph(f)`, `xs.filter(p).morph(f)`},
+		{false, "xs.filter(p).\nmorph(f)", "xs.filter(p).morph(f)"},
+		{false, "xs.filter(p).\n\n\tmorph(f)", "xs.filter(p).morph(f)"},
+		{false, "xs.filter(p). // even\nmorph(f)", "xs.filter(p). // even\n\t\t\t\tmorph(f)"},
+		// the first call is left where it is
+		{false, "xs.\nfilter(p)", "xs.\n\t\tfilter(p)"},
+		{false, "xs.\nfilter(p).\nmorph(f)", "xs.\n\t\tfilter(p).morph(f)"},
+		// long chains are split
+		{false, `xs.filter(p).morph(f).fold(g)`, "xs.filter(p).\n\t\tmorph(f).\n\t\tfold(g)"},
+		{false, "xs.filter(p).morph(f).\nfold(g)", "xs.filter(p).\n\t\tmorph(f).\n\t\tfold(g)"},
+		{false, `enum(3).filter(p).morph(f).fold(g)`, "enum(3).filter(p).\n\t\tmorph(f).\n\t\tfold(g)"},
+		{false, `xss.flatten().morph(f).fold(g)`, "xss.flatten().\n\t\tmorph(f).\n\t\tfold(g)"},
+		// non-ply methods do not count towards the chain
+		{false, `x.Add(y).Sub(z).Mul(w)`, `x.Add(y).Sub(z).Mul(w)`},
+		{false, `x.Add(y).filter(p).morph(f)`, `x.Add(y).filter(p).morph(f)`},
+		// nested chains
+		{false, `zip(f, xs.filter(p).reverse().take(3), ys)`, "zip(f, xs.filter(p).\n\t\treverse().\n\t\ttake(3), ys)"},
+		{false, "xs.filter(p).\nreverse().take(3).morph(func(x int) int { return ys.filter(q).\ntake(x).fold(g) })", "xs.filter(p).\n\t\treverse().\n\t\ttake(3).\n\t\tmorph(func(x int) int {\n\t\t\treturn ys.filter(q).\n\t\t\t\ttake(x).\n\t\t\t\tfold(g)\n\t\t})"},
+		{false, "xs.filter(p).reverse().take(3).morph(func(x int) []int { return ys.filter(q).\ntake(x) })", "xs.filter(p).\n\t\treverse().\n\t\ttake(3).\n\t\tmorph(func(x int) []int {\n\t\t\treturn ys.filter(q).take(x)\n\t\t})"},
+
+		// simplifications
+		{true, `not(not(even))`, `even`},
+		{true, `not(not(not(even)))`, `not(even)`},
+		{false, `not(not(even))`, `not(not(even))`},
+		{true, `merge(m)`, `m`},
+		{true, `merge(m, n)`, `merge(m, n)`},
+		{true, `merge(ms...)`, `merge(ms...)`},
+		{true, `len([]int{1, 2}.filter(func(x int) bool { return x > 0 || x == -1 }).take(1)) > 0`, `[]int{1, 2}.any(func(x int) bool { return x > 0 || x == -1 })`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) != 0`, `[]int(xs).any(func(x int) bool { return x > 0 })`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) >= 1`, `[]int(xs).any(func(x int) bool { return x > 0 })`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) == 1`, `[]int(xs).any(func(x int) bool { return x > 0 })`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) == 0`, `![]int(xs).any(func(x int) bool { return x > 0 })`},
+		{false, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(1)) > 0`},
+		// xs may declare its own filter
+		{true, `len(xs.filter(func(x int) bool { return x > 0 }).take(1)) > 0`, `len(xs.filter(func(x int) bool { return x > 0 }).take(1)) > 0`},
+		// p may have side effects or panic, and take calls it on more elements
+		// than any
+		{true, `len([]int(xs).filter(p).take(1)) > 0`, `len([]int(xs).filter(p).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return f(x) }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { return f(x) }).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { n++; return true }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { n++; return true }).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return <-c }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { return <-c }).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return 10/x > 0 }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { return 10/x > 0 }).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > k }).take(1)) > 0`, `len([]int(xs).filter(func(x int) bool { return x > k }).take(1)) > 0`},
+		{true, `len([]T(xs).filter(func(x T) bool { return x == x }).take(1)) > 0`, `len([]T(xs).filter(func(x T) bool { return x == x }).take(1)) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 })) > 0`, `len([]int(xs).filter(func(x int) bool { return x > 0 })) > 0`},
+		{true, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(2)) > 0`, `len([]int(xs).filter(func(x int) bool { return x > 0 }).take(2)) > 0`},
+		{true, `len(m.filter(func(k, v int) bool { return k > v }).take(1)) > 0`, `len(m.filter(func(k, v int) bool { return k > v }).take(1)) > 0`},
+	}
+	const prefix = "package p\n\nfunc f() {\n\t_ = "
+	const suffix = "\n}\n"
+	for _, test := range tests {
+		res, err := Source([]byte(prefix+test.in+suffix), test.simplify)
+		if err != nil {
+			t.Errorf("%v: %v", test.in, err)
+			continue
+		}
+		if exp := prefix + test.out + suffix; string(res) != exp {
+			t.Errorf("%v: expected\n%s\ngot\n%s", test.in, exp, res)
+		}
+		// formatting is idempotent
+		if again, err := Source(res, test.simplify); err != nil || string(again) != string(res) {
+			t.Errorf("%v: formatting again produced\n%s", test.in, again)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package format
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/tsuna/gorewrite"
+)
+
+// Simplify applies the following rewrites to f:
+//
+//    not(not(f))                    =>  f
+//    merge(m)                       =>  m
+//    len(xs.filter(p).take(1)) > 0  =>  xs.any(p)
+//    len(xs.filter(p).take(1)) == 0 =>  !xs.any(p)
+//
+// In the last two rewrites, != 0, >= 1, and == 1 are treated the same as > 0.
+// Since Simplify does not have access to type information, it assumes that
+// the ply builtins are not shadowed, and rewrites only what it can prove does
+// not change the behavior of the program. The filter must therefore be
+// called on a slice literal or conversion, whose type cannot declare its own
+// methods, and p must be a function literal whose result cannot panic (see
+// isSimplePredicate). Such a predicate has no side effects, so it makes no
+// difference that filter and take call it on more elements than any would.
+func Simplify(f *ast.File) {
+	gorewrite.Rewrite(simplifier{}, f)
+}
+
+type simplifier struct{}
+
+func (s simplifier) Rewrite(node ast.Node) (ast.Node, gorewrite.Rewriter) {
+	switch n := node.(type) {
+	case *ast.CallExpr:
+		switch fnName(n) {
+		case "not":
+			// not(not(f)) => f
+			if len(n.Args) == 1 {
+				if inner, ok := unparen(n.Args[0]).(*ast.CallExpr); ok && fnName(inner) == "not" && len(inner.Args) == 1 {
+					return s.Rewrite(inner.Args[0])
+				}
+			}
+		case "merge":
+			// merge(m) => m
+			if len(n.Args) == 1 && !n.Ellipsis.IsValid() {
+				return s.Rewrite(n.Args[0])
+			}
+		}
+
+	case *ast.BinaryExpr:
+		if call, ok := takeOneLen(n.X); ok {
+			switch {
+			case n.Op == token.GTR && isLit(n.Y, "0"),
+				n.Op == token.NEQ && isLit(n.Y, "0"),
+				n.Op == token.GEQ && isLit(n.Y, "1"),
+				n.Op == token.EQL && isLit(n.Y, "1"):
+				return call, s
+			case n.Op == token.EQL && isLit(n.Y, "0"):
+				return &ast.UnaryExpr{OpPos: n.Pos(), Op: token.NOT, X: call}, s
+			}
+		}
+	}
+	return node, s
+}
+
+// takeOneLen reports whether e has the form len(xs.filter(p).take(1)), where
+// xs and p satisfy the conditions described by Simplify. If so, it returns
+// the equivalent call to xs.any(p).
+func takeOneLen(e ast.Expr) (*ast.CallExpr, bool) {
+	c, ok := unparen(e).(*ast.CallExpr)
+	if !ok || fnName(c) != "len" || len(c.Args) != 1 {
+		return nil, false
+	}
+	take, ok := unparen(c.Args[0]).(*ast.CallExpr)
+	if !ok || len(take.Args) != 1 || !isLit(take.Args[0], "1") {
+		return nil, false
+	}
+	sel, ok := take.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "take" {
+		return nil, false
+	}
+	filter, ok := sel.X.(*ast.CallExpr)
+	if !ok || len(filter.Args) != 1 {
+		return nil, false
+	}
+	sel, ok = filter.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "filter" || !isSliceLit(sel.X) || !isSimplePredicate(filter.Args[0]) {
+		return nil, false
+	}
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: sel.X, Sel: &ast.Ident{NamePos: sel.Sel.Pos(), Name: "any"}},
+		Args: filter.Args,
+	}, true
+}
+
+// isSliceLit reports whether e is a composite literal or conversion of an
+// unnamed slice type, e.g. []int{1, 2} or []int(xs).
+func isSliceLit(e ast.Expr) bool {
+	var typ ast.Expr
+	switch e := unparen(e).(type) {
+	case *ast.CompositeLit:
+		typ = e.Type
+	case *ast.CallExpr:
+		if len(e.Args) != 1 || e.Ellipsis.IsValid() {
+			return false
+		}
+		typ = unparen(e.Fun)
+	}
+	at, ok := typ.(*ast.ArrayType)
+	return ok && at.Len == nil
+}
+
+// isSimplePredicate reports whether e is a function literal with a single
+// parameter whose body returns an expression that cannot panic: one built
+// from the parameter, literals, and the predeclared constants using only the
+// logical, comparison, and non-dividing arithmetic operators. The parameter
+// may not be compared to itself, lest it hold an incomparable interface.
+func isSimplePredicate(e ast.Expr) bool {
+	lit, ok := e.(*ast.FuncLit)
+	if !ok || lit.Type.Params.NumFields() != 1 || len(lit.Body.List) != 1 {
+		return false
+	}
+	ret, ok := lit.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return false
+	}
+	var param string
+	if names := lit.Type.Params.List[0].Names; len(names) == 1 {
+		param = names[0].Name
+	}
+	isParam := func(e ast.Expr) bool {
+		id, ok := unparen(e).(*ast.Ident)
+		return ok && id.Name == param
+	}
+	var safe func(e ast.Expr) bool
+	safe = func(e ast.Expr) bool {
+		switch e := unparen(e).(type) {
+		case *ast.BasicLit:
+			return true
+		case *ast.Ident:
+			return e.Name == param || e.Name == "true" || e.Name == "false" || e.Name == "nil"
+		case *ast.UnaryExpr:
+			switch e.Op {
+			case token.NOT, token.ADD, token.SUB, token.XOR:
+				return safe(e.X)
+			}
+		case *ast.BinaryExpr:
+			switch e.Op {
+			case token.EQL, token.NEQ:
+				if isParam(e.X) && isParam(e.Y) {
+					return false
+				}
+				fallthrough
+			case token.LAND, token.LOR, token.LSS, token.LEQ, token.GTR, token.GEQ,
+				token.ADD, token.SUB, token.MUL, token.AND, token.OR, token.XOR, token.AND_NOT:
+				return safe(e.X) && safe(e.Y)
+			}
+		}
+		return false
+	}
+	return safe(ret.Results[0])
+}
+
+// fnName returns the name of the function called by c, if it is called via a
+// plain identifier.
+func fnName(c *ast.CallExpr) string {
+	if id, ok := unparen(c.Fun).(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+func isLit(e ast.Expr, value string) bool {
+	lit, ok := unparen(e).(*ast.BasicLit)
+	return ok && lit.Kind == token.INT && lit.Value == value
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
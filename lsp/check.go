@@ -0,0 +1,397 @@
+package lsp
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/lukechampine/ply/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A snapshot is the result of parsing and type-checking the package
+// containing a document.
+type snapshot struct {
+	fset  *token.FileSet
+	pkg   *types.Package
+	info  *types.Info
+	files map[string]*ast.File // keyed by path
+	srcs  map[string][]byte    // keyed by path
+	errs  []error              // types.Error or scanner.Error
+}
+
+// packageFiles returns the paths of the .go and .ply files that belong to the
+// same package as the file at path. Test files are only included if path is
+// itself a test file, and previous codegen is always excluded.
+func packageFiles(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	isTest := func(name string) bool {
+		return strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, "_test.ply")
+	}
+	paths := []string{path}
+	for _, info := range infos {
+		name := info.Name()
+		p := filepath.Join(dir, name)
+		if info.IsDir() || p == path || strings.HasPrefix(name, "ply-") {
+			continue
+		} else if !(strings.HasSuffix(name, ".go") || strings.HasSuffix(name, ".ply")) {
+			continue
+		} else if isTest(name) && !isTest(path) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// check parses and type-checks the package containing the document at path.
+// The contents of open documents are used in place of their contents on
+// disk. Files whose package clause does not match that of the document are
+// ignored.
+func (s *Server) check(path string) (*snapshot, error) {
+	paths, err := packageFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{
+		fset:  token.NewFileSet(),
+		files: make(map[string]*ast.File),
+		srcs:  make(map[string][]byte),
+		info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		},
+	}
+	var files []*ast.File
+	for _, p := range paths {
+		src, ok := s.docs[p]
+		if !ok {
+			if src, err = ioutil.ReadFile(p); err != nil {
+				continue
+			}
+		}
+		f, err := parser.ParseFile(snap.fset, p, src, parser.AllErrors|parser.ParseComments)
+		if f == nil || (p != path && (len(files) == 0 || f.Name.Name != files[0].Name.Name)) {
+			continue
+		}
+		if list, ok := err.(scanner.ErrorList); ok && p == path {
+			for _, e := range list {
+				snap.errs = append(snap.errs, e)
+			}
+		}
+		files = append(files, f)
+		snap.files[p] = f
+		snap.srcs[p] = src
+	}
+
+	conf := types.Config{
+		Importer: s.importer,
+		Error: func(err error) {
+			snap.errs = append(snap.errs, err)
+		},
+	}
+	snap.pkg, _ = conf.Check("", snap.fset, files, snap.info)
+	return snap, nil
+}
+
+// position converts pos to an LSP position within the file containing it.
+func (snap *snapshot) position(pos token.Position) Position {
+	src := snap.srcs[pos.Filename]
+	start := lineStart(src, pos.Line)
+	end := start + pos.Column - 1
+	if end > len(src) {
+		end = len(src)
+	}
+	return Position{
+		Line:      pos.Line - 1,
+		Character: len(utf16.Encode(bytes.Runes(src[start:end]))),
+	}
+}
+
+// rangeAt returns the range of the identifier or token beginning at pos.
+func (snap *snapshot) rangeAt(pos token.Position) Range {
+	r := Range{Start: snap.position(pos)}
+	src := snap.srcs[pos.Filename]
+	off := lineStart(src, pos.Line) + pos.Column - 1
+	n := 0
+	for off+n < len(src) {
+		c, size := utf8.DecodeRune(src[off+n:])
+		if !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_') {
+			break
+		}
+		n += size
+	}
+	if n == 0 && off < len(src) && src[off] != '\n' {
+		n = 1
+	}
+	pos.Column += n
+	r.End = snap.position(pos)
+	return r
+}
+
+// pos converts an LSP position within the file at path to a token.Pos.
+func (snap *snapshot) pos(path string, p Position) token.Pos {
+	f, ok := snap.files[path]
+	if !ok {
+		return token.NoPos
+	}
+	src := snap.srcs[path]
+	off := lineStart(src, p.Line+1)
+	for units := 0; units < p.Character && off < len(src) && src[off] != '\n'; {
+		c, size := utf8.DecodeRune(src[off:])
+		units += len(utf16.Encode([]rune{c}))
+		off += size
+	}
+	return snap.fset.File(f.Pos()).Pos(off)
+}
+
+// lineStart returns the offset of the beginning of the (1-based) line in src.
+func lineStart(src []byte, line int) int {
+	off := 0
+	for ; line > 1; line-- {
+		i := bytes.IndexByte(src[off:], '\n')
+		if i < 0 {
+			return len(src)
+		}
+		off += i + 1
+	}
+	return off
+}
+
+// diagnostics returns the diagnostics for the file at path.
+func (snap *snapshot) diagnostics(path string) []Diagnostic {
+	diags := []Diagnostic{}
+	for _, err := range snap.errs {
+		var pos token.Position
+		var msg string
+		severity := severityError
+		switch err := err.(type) {
+		case types.Error:
+			pos, msg = err.Fset.Position(err.Pos), err.Msg
+			if err.Soft {
+				severity = severityWarning
+			}
+		case *scanner.Error:
+			pos, msg = err.Pos, err.Msg
+		default:
+			continue
+		}
+		if pos.Filename != path {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    snap.rangeAt(pos),
+			Severity: severity,
+			Source:   "ply",
+			Message:  msg,
+		})
+	}
+	return diags
+}
+
+// identAt returns the innermost identifier enclosing pos in the file at
+// path, along with the path of nodes enclosing it.
+func (snap *snapshot) identAt(path string, p Position) (*ast.Ident, []ast.Node) {
+	pos := snap.pos(path, p)
+	if !pos.IsValid() {
+		return nil, nil
+	}
+	nodes, _ := astutil.PathEnclosingInterval(snap.files[path], pos, pos)
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	id, ok := nodes[0].(*ast.Ident)
+	if !ok {
+		return nil, nil
+	}
+	return id, nodes
+}
+
+// isPlyMethod reports whether the selector e denotes a ply method.
+func (snap *snapshot) isPlyMethod(e *ast.SelectorExpr) bool {
+	sel, ok := snap.info.Selections[e]
+	if !ok || sel.Kind() != types.MethodVal || sel.Obj().Pkg() != nil {
+		return false
+	}
+	// ply methods may be promoted from embedded fields, so the receiver need
+	// not be a slice or map; but unlike the Error method of error, they have
+	// no receiver of their own
+	return sel.Obj().Type().(*types.Signature).Recv() == nil
+}
+
+// plySignature returns the signature of the ply function or method called by
+// call, as instantiated by the types of its arguments. name is the name of
+// the function or method, and recv is the receiver expression, if any.
+func (snap *snapshot) plySignature(call *ast.CallExpr, name string, recv ast.Expr) string {
+	qf := types.RelativeTo(snap.pkg)
+	var params []string
+	for _, arg := range call.Args {
+		params = append(params, types.TypeString(snap.info.Types[arg].Type, qf))
+	}
+	sig := "func "
+	if recv != nil {
+		sig += "(" + types.TypeString(snap.info.Types[recv].Type, qf) + ")."
+	}
+	sig += name + "(" + strings.Join(params, ", ") + ")"
+	switch res := snap.info.Types[call].Type.(type) {
+	case nil:
+	case *types.Tuple:
+		if res.Len() > 0 {
+			sig += " " + types.TypeString(res, qf)
+		}
+	default:
+		if res != types.Typ[types.Invalid] {
+			sig += " " + types.TypeString(res, qf)
+		}
+	}
+	return sig
+}
+
+// hover returns a description of the identifier at p in the file at path.
+// Calls to ply functions and methods are described by their instantiated
+// signatures.
+func (snap *snapshot) hover(path string, p Position) *Hover {
+	id, nodes := snap.identAt(path, p)
+	if id == nil {
+		return nil
+	}
+	var text string
+	switch parent := nodes[1].(type) {
+	case *ast.SelectorExpr:
+		if call, ok := nodes[2].(*ast.CallExpr); ok && call.Fun == parent && parent.Sel == id && snap.isPlyMethod(parent) {
+			text = snap.plySignature(call, id.Name, parent.X)
+		}
+	case *ast.CallExpr:
+		if _, ok := snap.info.Uses[id].(*types.Ply); ok && parent.Fun == id {
+			text = snap.plySignature(parent, id.Name, nil)
+		}
+	}
+	if text == "" {
+		obj := snap.info.Uses[id]
+		if obj == nil {
+			obj = snap.info.Defs[id]
+		}
+		if obj == nil {
+			return nil
+		}
+		text = types.ObjectString(obj, types.RelativeTo(snap.pkg))
+	}
+
+	r := snap.rangeAt(snap.fset.Position(id.Pos()))
+	return &Hover{
+		Contents: markupContent{Kind: "markdown", Value: "```go\n" + text + "\n```"},
+		Range:    &r,
+	}
+}
+
+// completion returns the methods and fields that may follow the selector
+// expression at p in the file at path, including ply methods.
+func (snap *snapshot) completion(path string, p Position) []CompletionItem {
+	pos := snap.pos(path, p)
+	f, ok := snap.files[path]
+	if !ok || !pos.IsValid() {
+		return nil
+	}
+
+	// find the innermost selector whose dot precedes pos and whose selector
+	// (which may be missing) contains pos
+	var sel *ast.SelectorExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		e, ok := n.(*ast.SelectorExpr)
+		if ok && e.X.End() < pos && (pos <= e.Sel.End() || e.Sel.Name == "_") {
+			sel = e
+		}
+		return n == nil || (n.Pos() <= pos && pos <= n.End()+1)
+	})
+	if sel == nil {
+		return nil
+	}
+	tv, ok := snap.info.Types[sel.X]
+	if !ok || tv.Type == nil {
+		return nil
+	}
+	T := tv.Type
+
+	qf := types.RelativeTo(snap.pkg)
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	add := func(name string, kind int, detail string) {
+		if !seen[name] {
+			seen[name] = true
+			items = append(items, CompletionItem{Label: name, Kind: kind, Detail: detail})
+		}
+	}
+	if st, ok := T.Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			add(st.Field(i).Name(), kindField, types.TypeString(st.Field(i).Type(), qf))
+		}
+	}
+	mset := types.NewMethodSet(types.NewPointer(T))
+	if _, ok := T.(*types.Pointer); ok || types.IsInterface(T) {
+		mset = types.NewMethodSet(T)
+	}
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i).Obj()
+		add(m.Name(), kindMethod, types.TypeString(m.Type(), qf))
+	}
+	for _, name := range types.PlyMethodNames(T) {
+		add(name, kindMethod, plyMethodDetail(types.PlyMethodRecv(T, name), name))
+	}
+	return items
+}
+
+// plyMethodDetail returns the generic signatures of the ply method name on T,
+// as recorded in the builtin registry.
+func plyMethodDetail(T types.Type, name string) string {
+	_, isMap := T.Underlying().(*types.Map)
+	var sigs []string
+	for _, b := range types.PlyBuiltins() {
+		if b.Name == name && b.Recv != "" && strings.HasPrefix(b.Recv, "map") == isMap {
+			sigs = append(sigs, "func "+b.Sig)
+		}
+	}
+	return strings.Join(sigs, "\n")
+}
+
+// definition returns the location of the declaration of the identifier at p
+// in the file at path. Ply functions and methods have no declaration.
+func (snap *snapshot) definition(path string, p Position) *Location {
+	id, _ := snap.identAt(path, p)
+	if id == nil {
+		return nil
+	}
+	obj := snap.info.Uses[id]
+	if obj == nil {
+		obj = snap.info.Defs[id]
+	}
+	if obj == nil || !obj.Pos().IsValid() {
+		return nil
+	}
+	pos := snap.fset.Position(obj.Pos())
+	if _, ok := snap.srcs[pos.Filename]; !ok {
+		src, err := ioutil.ReadFile(pos.Filename)
+		if err != nil {
+			return nil
+		}
+		snap.srcs[pos.Filename] = src
+	}
+	return &Location{
+		URI:   pathToURI(pos.Filename),
+		Range: snap.rangeAt(pos),
+	}
+}
@@ -0,0 +1,180 @@
+// Package lsp implements a Language Server Protocol server for Ply.
+//
+// The server type-checks each open .ply (or .go) document along with the
+// other files in its package, using Ply's type-checker, so that calls to Ply
+// builtins are understood. It supports diagnostics, hover, completion, and
+// go-to-definition. Documents are synchronized in full on every change.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	"github.com/lukechampine/ply/importer"
+	"github.com/lukechampine/ply/types"
+)
+
+// A Server is a Language Server Protocol server for .ply files. Its methods
+// are not safe for concurrent use.
+type Server struct {
+	w        io.Writer
+	importer types.Importer
+	docs     map[string][]byte // open documents, keyed by path
+	shutdown bool
+}
+
+// NewServer returns a server that writes responses and notifications to w.
+func NewServer(w io.Writer) *Server {
+	return &Server{
+		w:        w,
+		importer: importer.Default(),
+		docs:     make(map[string][]byte),
+	}
+}
+
+// Serve reads messages from r and handles them until the client sends an
+// exit notification or r is exhausted.
+func (s *Server) Serve(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a single request or notification.
+func (s *Server) handle(msg *message) error {
+	var result interface{}
+	var rerr *responseError
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]string{"name": "ply"},
+		}
+
+	case "shutdown":
+		s.shutdown = true
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			return s.update(params.TextDocument.URI, []byte(params.TextDocument.Text))
+		}
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if json.Unmarshal(msg.Params, &params) == nil && len(params.ContentChanges) > 0 {
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			return s.update(params.TextDocument.URI, []byte(text))
+		}
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if json.Unmarshal(msg.Params, &params) == nil {
+			if path, err := uriToPath(params.TextDocument.URI); err == nil {
+				delete(s.docs, path)
+			}
+			return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+				URI:         params.TextDocument.URI,
+				Diagnostics: []Diagnostic{},
+			})
+		}
+
+	case "textDocument/hover", "textDocument/completion", "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			rerr = &responseError{Code: codeParseError, Message: err.Error()}
+			break
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			rerr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+			break
+		}
+		snap, err := s.check(path)
+		if err != nil {
+			rerr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+			break
+		}
+		switch msg.Method {
+		case "textDocument/hover":
+			if h := snap.hover(path, params.Position); h != nil {
+				result = h
+			}
+		case "textDocument/completion":
+			result = snap.completion(path, params.Position)
+		case "textDocument/definition":
+			if loc := snap.definition(path, params.Position); loc != nil {
+				result = loc
+			}
+		}
+
+	default:
+		rerr = &responseError{Code: codeMethodNotFound, Message: "method not supported: " + msg.Method}
+	}
+
+	if msg.ID == nil {
+		// notifications do not receive responses
+		return nil
+	}
+	if result == nil && rerr == nil {
+		result = json.RawMessage("null")
+	}
+	return writeMessage(s.w, &message{ID: msg.ID, Result: result, Error: rerr})
+}
+
+// update sets the contents of the document at uri and publishes diagnostics
+// for each open document in its package.
+func (s *Server) update(uri string, text []byte) error {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil // ignore non-file documents
+	}
+	s.docs[path] = text
+	snap, err := s.check(path)
+	if err != nil {
+		return nil
+	}
+	for p := range snap.files {
+		if _, open := s.docs[p]; !open || filepath.Dir(p) != filepath.Dir(path) {
+			continue
+		}
+		err := s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         pathToURI(p),
+			Diagnostics: snap.diagnostics(p),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify sends a notification to the client.
+func (s *Server) notify(method string, params interface{}) error {
+	js, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.w, &message{Method: method, Params: js})
+}
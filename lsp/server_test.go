@@ -0,0 +1,171 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testSrc = `package main
+
+func main() {
+	xs := []int{1, 2, 3}
+	ys := xs.filter(func(x int) bool { return x > 1 })
+	var s string = ys
+	_ = b.take(1)
+	_ = xs.
+}
+
+type ints []int
+
+type batch struct{ ints }
+
+var b batch
+`
+
+// request frames a JSON-RPC message with the given id, method, and params.
+// If id is 0, the message is a notification.
+func request(t *testing.T, buf *bytes.Buffer, id int, method string, params interface{}) {
+	js, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &message{Method: method, Params: js}
+	if id != 0 {
+		raw := json.RawMessage(strconv.Itoa(id))
+		msg.ID = &raw
+	}
+	if err := writeMessage(buf, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// responses decodes every message written to buf.
+func responses(t *testing.T, buf *bytes.Buffer) []map[string]json.RawMessage {
+	var msgs []map[string]json.RawMessage
+	r := bufio.NewReader(buf)
+	for r.Buffered() > 0 || buf.Len() > 0 {
+		msg, err := readMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, _ := json.Marshal(msg)
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(js, &m); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ply-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.ply")
+	if err := ioutil.WriteFile(path, []byte(testSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(path)
+	doc := textDocumentIdentifier{URI: uri}
+	at := func(line, char int) textDocumentPositionParams {
+		return textDocumentPositionParams{TextDocument: doc, Position: Position{Line: line, Character: char}}
+	}
+
+	var in, out bytes.Buffer
+	request(t, &in, 1, "initialize", struct{}{})
+	request(t, &in, 0, "textDocument/didOpen", didOpenParams{
+		TextDocument: textDocumentItem{URI: uri, Version: 1, Text: testSrc},
+	})
+	request(t, &in, 2, "textDocument/hover", at(4, 12))      // filter
+	request(t, &in, 3, "textDocument/completion", at(7, 8))  // xs.
+	request(t, &in, 4, "textDocument/definition", at(5, 16)) // ys
+	request(t, &in, 5, "textDocument/hover", at(6, 8))       // take
+	request(t, &in, 6, "textDocument/completion", at(6, 7))  // b.
+	request(t, &in, 7, "shutdown", nil)
+	request(t, &in, 0, "exit", nil)
+	if err := NewServer(&out).Serve(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := responses(t, &out)
+	if len(msgs) != 8 {
+		t.Fatalf("expected 8 messages, got %v", len(msgs))
+	}
+
+	// diagnostics
+	var diags publishDiagnosticsParams
+	if err := json.Unmarshal(msgs[1]["params"], &diags); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, d := range diags.Diagnostics {
+		if d.Range.Start.Line == 5 && strings.Contains(d.Message, "cannot use ys") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected type error on line 6, got %+v", diags.Diagnostics)
+	}
+
+	// hover
+	var hover Hover
+	if err := json.Unmarshal(msgs[2]["result"], &hover); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "func ([]int).filter(func(x int) bool) []int"; !strings.Contains(hover.Contents.Value, exp) {
+		t.Errorf("expected hover to contain %q, got %q", exp, hover.Contents.Value)
+	}
+
+	// completion
+	var items []CompletionItem
+	if err := json.Unmarshal(msgs[3]["result"], &items); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]bool)
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, name := range []string{"filter", "morph", "fold", "reverse"} {
+		if !labels[name] {
+			t.Errorf("expected completion for %q, got %+v", name, items)
+		}
+	}
+
+	// definition
+	var loc Location
+	if err := json.Unmarshal(msgs[4]["result"], &loc); err != nil {
+		t.Fatal(err)
+	}
+	if loc.URI != uri || loc.Range.Start != (Position{Line: 4, Character: 1}) {
+		t.Errorf("unexpected definition location: %+v", loc)
+	}
+
+	// ply methods promoted from an embedded slice
+	if err := json.Unmarshal(msgs[5]["result"], &hover); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "func (batch).take(int) ints"; !strings.Contains(hover.Contents.Value, exp) {
+		t.Errorf("expected hover to contain %q, got %q", exp, hover.Contents.Value)
+	}
+	items = nil
+	if err := json.Unmarshal(msgs[6]["result"], &items); err != nil {
+		t.Fatal(err)
+	}
+	details := make(map[string]string)
+	for _, item := range items {
+		details[item.Label] = item.Detail
+	}
+	if d := details["filter"]; !strings.Contains(d, "([]T).filter") {
+		t.Errorf("expected slice completion for promoted filter, got %q", d)
+	}
+}
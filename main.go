@@ -9,9 +9,12 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/lukechampine/ply/codegen"
+	"github.com/lukechampine/ply/lsp"
 )
 
 var (
@@ -79,16 +82,102 @@ func packages(args []string, xtest bool) (map[string][]string, error) {
 	return pkgs, nil
 }
 
+// writePlyFiles writes each compiled .ply file to dir, prefixing its name with
+// "ply-" and replacing its extension with .go. It returns the names of the
+// written files.
+func writePlyFiles(dir string, plyFiles map[string][]byte) ([]string, error) {
+	var filenames []string
+	for name, code := range plyFiles {
+		// .go -> .ply
+		filename := filepath.Join(dir, "ply-"+strings.Replace(filepath.Base(name), ".ply", ".go", -1))
+		if err := ioutil.WriteFile(filename, code, 0666); err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames, nil
+}
+
+// compilePackages compiles the .ply files of each package and writes them to
+// the package directory. At most p packages are compiled concurrently. If any
+// package fails to compile, one of the errors is returned, unless
+// conf.AllErrors is set, in which case the errors of every package are
+// combined into a codegen.ErrorList.
+func compilePackages(conf *codegen.Config, pkgs map[string][]string, p int) error {
+	if p < 1 {
+		p = 1
+	}
+	dirs := make(chan string)
+	errs := make(chan error, len(pkgs))
+	var wg sync.WaitGroup
+	for i := 0; i < p; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirs {
+				plyFiles, err := conf.Compile(pkgs[dir])
+				if err == nil {
+					_, err = writePlyFiles(dir, plyFiles)
+				}
+				errs <- err
+			}
+		}()
+	}
+	for dir := range pkgs {
+		dirs <- dir
+	}
+	close(dirs)
+	wg.Wait()
+	close(errs)
+
+	var all codegen.ErrorList
+	for err := range errs {
+		if list, ok := err.(codegen.ErrorList); ok {
+			all = append(all, list...)
+		} else if err != nil {
+			all = append(all, err)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	} else if !conf.AllErrors {
+		return all[0]
+	}
+	return all
+}
+
 func main() {
 	log.SetFlags(0)
 	goFlags := flag.String("goflags", "", "Flags to be supplied to the Go compiler")
+	parallel := flag.Int("p", runtime.NumCPU(), "Number of packages that can be compiled in parallel")
+	allErrors := flag.Bool("e", false, "Report all errors, not just the first")
+	jsonDiags := flag.Bool("json", false, "Report errors as a stream of JSON diagnostics on stdout; implies -e")
+	var alloc codegen.AllocPolicy
+	flag.Var(&alloc, "ply.alloc", "When to preallocate returned slices: exact, upper, or grow")
+	strict := flag.Bool("ply.strict", false, "Warn about chains that are not pipelined because a callback may have side effects")
 	flag.Parse()
 	args := flag.Args()
 	if len(args) == 0 || args[0] == "version" {
 		fmt.Printf("ply v%s\nCommit: %s\nBuild Date: %s\n", version, githash, builddate)
 		return
+	} else if args[0] == "fmt" {
+		fmtMain(args[1:])
+		return
+	} else if args[0] == "doc" {
+		docMain(args[1:])
+		return
+	} else if args[0] == "lsp" {
+		// serve the Language Server Protocol over stdio
+		if err := lsp.NewServer(os.Stdout).Serve(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
+	conf := &codegen.Config{AllErrors: *allErrors || *jsonDiags, Alloc: alloc}
+	if *strict {
+		conf.Warn = func(err error) { reportWarning(err, *jsonDiags) }
+	}
 	if isFileList(args[1:]) {
 		dir, pkg, err := adhoc(args[1:])
 		if err != nil {
@@ -104,20 +193,16 @@ func main() {
 		}
 		args = noply
 
-		plyFiles, err := codegen.Compile(pkg)
+		plyFiles, err := conf.Compile(pkg)
 		if err != nil {
-			log.Fatal(err)
+			reportErrors(err, *jsonDiags)
 		}
-		for name, code := range plyFiles {
-			// .go -> .ply
-			filename := filepath.Join(dir, "ply-"+strings.Replace(filepath.Base(name), ".ply", ".go", -1))
-			err = ioutil.WriteFile(filename, code, 0666)
-			if err != nil {
-				log.Fatal(err)
-			}
-			// add compiled .ply files to args
-			args = append(args, filename)
+		filenames, err := writePlyFiles(dir, plyFiles)
+		if err != nil {
+			log.Fatal(err)
 		}
+		// add compiled .ply files to args
+		args = append(args, filenames...)
 	} else if args[0] == "run" {
 		log.Fatal("ply run: no .ply or .go files listed")
 	} else {
@@ -129,19 +214,8 @@ func main() {
 
 		// for each package, compile the .ply files and write them to the
 		// package directory.
-		for dir, pkg := range pkgs {
-			plyFiles, err := codegen.Compile(pkg)
-			if err != nil {
-				log.Fatal(err)
-			}
-			for name, code := range plyFiles {
-				// .go -> .ply
-				filename := filepath.Join(dir, "ply-"+strings.Replace(filepath.Base(name), ".ply", ".go", -1))
-				err = ioutil.WriteFile(filename, code, 0666)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
+		if err := compilePackages(conf, pkgs, *parallel); err != nil {
+			reportErrors(err, *jsonDiags)
 		}
 	}
 
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lukechampine/ply/codegen"
+	"github.com/lukechampine/ply/types"
+)
+
+// docImportPath is the import path of the doc pseudo-package.
+const docImportPath = "github.com/lukechampine/ply/doc"
+
+// docTypes replaces the placeholder receiver types of the doc pseudo-package
+// with the generic types they represent.
+var docTypes = strings.NewReplacer("SliceEntryTU", "[]struct{Key T; Val U}", "SliceSliceT", "[][]T", "SliceT", "[]T", "MapTU", "map[T]U", "MapTS", "map[T]S")
+
+// A docEntry is the documentation of a function or method in the doc
+// pseudo-package.
+type docEntry struct {
+	sig  string // in the notation of types.PlyBuiltin
+	text string
+}
+
+// lowerFirst lowercases the first letter of s.
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+// docKey returns the key used to associate builtins with their docs.
+func docKey(recv, name string) string {
+	if recv == "" {
+		return name
+	}
+	return "(" + recv + ")." + name
+}
+
+// loadDocs parses the doc pseudo-package in dir and returns the
+// documentation of each function and method, keyed by docKey.
+func loadDocs(dir string) (map[string]docEntry, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filepath.Join(dir, "doc.go"), nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string]docEntry)
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		var recv string
+		if fd.Recv != nil && len(fd.Recv.List) == 1 {
+			if id, ok := fd.Recv.List[0].Type.(*ast.Ident); ok {
+				recv = docTypes.Replace(id.Name)
+			}
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, fd.Type); err != nil {
+			return nil, err
+		}
+		name := lowerFirst(fd.Name.Name)
+		sig := name + docTypes.Replace(strings.TrimPrefix(buf.String(), "func"))
+		if recv != "" {
+			sig = "(" + recv + ")." + sig
+		}
+		docs[docKey(recv, name)] = docEntry{sig: sig, text: fd.Doc.Text()}
+	}
+	return docs, nil
+}
+
+// docMain implements the doc subcommand, which prints the signatures and
+// documentation of a ply builtin function or method. With no arguments, it
+// lists the signatures of all builtins.
+func docMain(args []string) {
+	fs := flag.NewFlagSet("doc", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ply doc [name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	builtins := types.PlyBuiltins()
+	if fs.NArg() == 0 {
+		for _, b := range builtins {
+			fmt.Println("func " + b.Sig)
+		}
+		return
+	} else if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// group the forms of the builtin by receiver
+	name := lowerFirst(fs.Arg(0))
+	var recvs []string
+	forms := make(map[string][]string)
+	for _, b := range builtins {
+		if b.Name != name {
+			continue
+		}
+		if _, ok := forms[b.Recv]; !ok {
+			recvs = append(recvs, b.Recv)
+		}
+		forms[b.Recv] = append(forms[b.Recv], b.Sig)
+	}
+	if len(recvs) == 0 {
+		fmt.Fprintf(os.Stderr, "ply doc: no builtin function or method named %s\n", fs.Arg(0))
+		os.Exit(1)
+	}
+
+	// the signatures are still useful if the docs can't be found
+	var docs map[string]docEntry
+	pkg, err := build.Import(docImportPath, "", build.FindOnly)
+	if err == nil {
+		docs, err = loadDocs(pkg.Dir)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ply doc: could not load documentation:", err)
+	}
+
+	for i, recv := range recvs {
+		if i > 0 {
+			fmt.Println()
+		}
+		for _, sig := range forms[recv] {
+			fmt.Println("func " + sig)
+		}
+		text := docs[docKey(recv, name)].text
+		for _, line := range strings.SplitAfter(text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				line = "    " + line
+			}
+			fmt.Print(line)
+		}
+		// methods that have caveats when pipelined describe them in their
+		// docs; otherwise, add a generic note
+		if recv != "" && codegen.CanPipeline(name, strings.HasPrefix(recv, "map")) && !strings.Contains(text, "pipelined") {
+			fmt.Printf("\n    Optimizations: %s can be pipelined. When chained with other\n", name)
+			fmt.Println("    pipelined methods, no intermediate results are allocated.")
+		}
+	}
+}
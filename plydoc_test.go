@@ -0,0 +1,178 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lukechampine/ply/types"
+)
+
+// TestDocRegistry checks that the builtin registry agrees with both the doc
+// pseudo-package and the methods known to the type-checker.
+func TestDocRegistry(t *testing.T) {
+	docs, err := loadDocs("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forms := make(map[string][]string)
+	methods := make(map[string][]string)
+	for _, b := range types.PlyBuiltins() {
+		key := docKey(b.Recv, b.Name)
+		forms[key] = append(forms[key], b.Sig)
+		if len(forms[key]) == 1 && b.Recv != "" {
+			methods[b.Recv] = append(methods[b.Recv], b.Name)
+		}
+	}
+
+	for key, sigs := range forms {
+		d, ok := docs[key]
+		if !ok {
+			t.Errorf("%v is not documented in the doc package", key)
+			continue
+		}
+		found := false
+		for _, sig := range sigs {
+			found = found || sig == d.sig
+		}
+		if !found {
+			t.Errorf("signature of %v in the doc package (%v) does not match any registered form: %q", key, d.sig, sigs)
+		}
+	}
+	for key := range docs {
+		if _, ok := forms[key]; !ok {
+			t.Errorf("%v is documented in the doc package, but is not registered", key)
+		}
+	}
+
+	// the registry must also agree with the type-checker
+	tests := []struct {
+		recv string
+		T    types.Type
+	}{
+		{"[]T", types.NewSlice(types.Typ[types.Int])},
+		{"map[T]U", types.NewMap(types.Typ[types.Int], types.Typ[types.String])},
+	}
+	for _, test := range tests {
+		if names := types.PlyMethodNames(test.T); !reflect.DeepEqual(names, methods[test.recv]) {
+			t.Errorf("methods of %v: type-checker has %v, registry has %v", test.recv, names, methods[test.recv])
+		}
+	}
+}
+
+// TestIsPlyMethod checks that IsPlyMethod recognizes every method in the
+// builtin registry, whatever its receiver, and nothing else.
+func TestIsPlyMethod(t *testing.T) {
+	methods := make(map[string]bool)
+	for _, b := range types.PlyBuiltins() {
+		methods[b.Name] = methods[b.Name] || b.Recv != ""
+	}
+	methods["len"] = false
+	methods["Filter"] = false
+	for name, isMethod := range methods {
+		if types.IsPlyMethod(name) != isMethod {
+			t.Errorf("IsPlyMethod(%q) = %v, want %v", name, !isMethod, isMethod)
+		}
+	}
+}
+
+// registryPlaceholders declares the type parameters of registry signatures
+// as distinct named types, so that a signature using the wrong one fails to
+// type-check. T is numeric and U is ordered, since some builtins require it.
+const registryPlaceholders = `
+type T int
+type U string
+type V float64
+type W bool
+type S struct{}
+`
+
+// TestRegistrySignatures checks that each signature in the builtin registry
+// agrees with the type-checker: calling the builtin with arguments of the
+// listed parameter types must type-check, and its results must be
+// assignable to the listed result types.
+func TestRegistrySignatures(t *testing.T) {
+	// the registry uses T for the argument of not, which must be a predicate
+	placeholders := map[string]string{
+		"not": "type T func(int) bool",
+	}
+
+	for _, b := range types.PlyBuiltins() {
+		// parse the signature as a function declaration
+		decl := "func " + b.Sig
+		if b.Recv != "" {
+			decl = "func (recv " + b.Recv + ") " + strings.TrimPrefix(b.Sig, "("+b.Recv+").")
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+decl, 0)
+		if err != nil {
+			t.Errorf("%v: %v", b.Sig, err)
+			continue
+		}
+		fn := f.Decls[0].(*ast.FuncDecl)
+
+		// declare a variable of each parameter and result type, and call the
+		// builtin with the parameters
+		var body, args, results, vars []string
+		declare := func(name string, typ ast.Expr) {
+			body = append(body, "var "+name+" "+types.ExprString(typ))
+			vars = append(vars, name)
+		}
+		if b.Recv != "" {
+			declare("recv", fn.Recv.List[0].Type)
+		}
+		for _, field := range fn.Type.Params.List {
+			for _, name := range field.Names {
+				if e, ok := field.Type.(*ast.Ellipsis); ok {
+					// variadic arguments are passed individually
+					declare(name.Name, e.Elt)
+					args = append(args, name.Name, name.Name)
+				} else {
+					declare(name.Name, field.Type)
+					args = append(args, name.Name)
+				}
+			}
+		}
+		if fn.Type.Results != nil {
+			for _, field := range fn.Type.Results.List {
+				n := len(field.Names)
+				if n == 0 {
+					n = 1
+				}
+				for i := 0; i < n; i++ {
+					name := "r" + strconv.Itoa(len(results))
+					declare(name, field.Type)
+					results = append(results, name)
+				}
+			}
+		}
+		call := b.Name + "(" + strings.Join(args, ", ") + ")"
+		if b.Recv != "" {
+			call = "recv." + call
+		}
+		if len(results) > 0 {
+			call = strings.Join(results, ", ") + " = " + call
+		}
+		body = append(body, call)
+		body = append(body, "_ = []interface{}{"+strings.Join(vars, ", ")+"}")
+
+		decls := registryPlaceholders
+		if p, ok := placeholders[b.Name]; ok {
+			decls = strings.Replace(decls, "type T int", p, 1)
+		}
+		src := "package p\n" + decls + "\nfunc f() {\n\t" + strings.Join(body, "\n\t") + "\n}\n"
+		fset := token.NewFileSet()
+		f, err = parser.ParseFile(fset, "registry.go", src, 0)
+		if err != nil {
+			t.Errorf("%v: %v\n%s", b.Sig, err, src)
+			continue
+		}
+		if _, err := new(types.Config).Check("p", fset, []*ast.File{f}, nil); err != nil {
+			t.Errorf("%v does not agree with the type-checker: %v\n%s", b.Sig, err, src)
+		}
+	}
+}
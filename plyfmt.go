@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukechampine/ply/format"
+)
+
+// fmtFile formats the .ply file at path according to the supplied flags. If
+// path is empty, stdin is formatted and printed to stdout.
+func fmtFile(path string, list, write, simplify bool) error {
+	var src []byte
+	var err error
+	if path == "" {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := format.Source(src, simplify)
+	if err != nil {
+		if path != "" {
+			err = fmt.Errorf("%s: %v", path, err)
+		}
+		return err
+	}
+
+	if path == "" {
+		_, err = os.Stdout.Write(res)
+		return err
+	}
+	changed := !bytes.Equal(src, res)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write && changed {
+		if err := ioutil.WriteFile(path, res, 0666); err != nil {
+			return err
+		}
+	}
+	if !list && !write {
+		_, err = os.Stdout.Write(res)
+	}
+	return err
+}
+
+// fmtMain implements the fmt subcommand, which formats .ply files in the
+// canonical Ply style. Its flags mirror those of gofmt. Directories are
+// searched recursively for .ply files.
+func fmtMain(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := fs.Bool("l", false, "list files whose formatting differs from ply fmt's")
+	write := fs.Bool("w", false, "write result to (source) file instead of stdout")
+	simplify := fs.Bool("s", false, "simplify code")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ply fmt [flags] [path ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "ply fmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := fmtFile("", false, false, *simplify); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	exitCode := 0
+	for _, arg := range fs.Args() {
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// format named files regardless of extension, but only .ply
+			// files in directories
+			if info.IsDir() || (path != arg && !strings.HasSuffix(path, ".ply")) {
+				return nil
+			}
+			if err := fmtFile(path, *list, *write, *simplify); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitCode = 2
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+		}
+	}
+	os.Exit(exitCode)
+}
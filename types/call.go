@@ -281,6 +281,16 @@ func (check *Checker) argument(fun ast.Expr, sig *Signature, i int, x *operand,
 		typ = typ.(*Slice).elem
 	}
 
+	// describe mismatched callbacks passed to ply methods in detail
+	if sel, ok := fun.(*ast.SelectorExpr); ok && x.mode != invalid {
+		if want, ok := typ.Underlying().(*Signature); ok && !x.assignableTo(check.conf, typ, nil) && IsPlyMethod(sel.Sel.Name) {
+			if check.plyCallbackArgument(x, sel, want) == nil {
+				x.mode = invalid
+				return
+			}
+		}
+	}
+
 	check.assignment(x, typ, check.sprintf("argument to %s", fun))
 }
 
@@ -354,8 +364,10 @@ func (check *Checker) selector(x *operand, e *ast.SelectorExpr) {
 	}
 
 	obj, index, indirect = LookupFieldOrMethod(x.typ, x.mode == variable, check.pkg, sel)
-	if obj == nil {
-		// check for ply method
+	if obj == nil && index == nil && !indirect {
+		// check for ply method. Declared fields and methods always take
+		// precedence, even if they are ambiguous or require an
+		// addressable receiver.
 		obj, index, indirect = lookupPlyMethod(x.typ, sel)
 	}
 	if obj == nil {
@@ -1,9 +1,14 @@
 package types
 
 import (
+	"fmt"
 	"go/ast"
 	"go/constant"
+	"go/parser"
 	"go/token"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // A plyId is the id of a ply function or method.
@@ -11,7 +16,9 @@ type plyId int
 
 const (
 	// funcs
-	_Enum plyId = iota
+	_Compose plyId = iota
+	_Enum
+	_FromEntries
 	_Max
 	_Merge
 	_Min
@@ -19,50 +26,208 @@ const (
 	_Zip
 	// methods
 	_All
+	_Alli
 	_Any
+	_Anyi
+	_Argmax
+	_Argmin
+	_BinarySearch
+	_Chunk
 	_Contains
+	_Count
+	_CountBy
+	_DedupSorted
+	_Difference
+	_Drop
 	_DropWhile
+	_Elems
+	_Entries
+	_Equal
 	_Filter
+	_Filteri
+	_Find
+	_FindIndex
+	_FlatMorph
+	_Flatten
 	_Fold
+	_Foldi
+	_Foreach
+	_Foreachi
+	_GroupBy
+	_IndexOf
+	_InsertSorted
+	_Intersect
+	_Invert
+	_IsSubset
+	_IsSuperset
+	_Keys
+	_LastIndexOf
+	_MapKeys
+	_MapValues
+	_MaxElem // ([]T).max, as opposed to the max function
+	_MaxBy
+	_Mean
+	_MergeSorted
+	_MinElem // ([]T).min, as opposed to the min function
+	_MinBy
 	_Morph
+	_Morphi
+	_Pairs
+	_Partition
+	_Product
 	_Reverse
 	_Sort
+	_SortBy
+	_SortDesc
+	_SortStable
+	_SortedEntries
+	_Sum
+	_SymmetricDifference
+	_Take
 	_TakeWhile
+	_Tee
 	_ToMap
 	_ToSet
+	_Union
+	_Uniq
+	_Window
 )
 
+// predeclaredPlyFuncs and predeclaredPlyMethods are the registry of ply
+// builtins. In addition to the information needed by the type-checker, each
+// entry lists the generic signatures of the builtin, one per receiver type
+// or calling form. These signatures are written in the same notation as the
+// doc pseudo-package, with T, U, V, and W standing for arbitrary types; the
+// two must agree. The type-checker reads the signatures of ordinary methods
+// directly from the registry (see plyMethods); the signatures of special
+// methods and funcs must agree with the type-checker, which is tested by
+// calling the builtin with arguments of the listed types.
 var predeclaredPlyFuncs = [...]struct {
 	name     string
 	nargs    int
 	variadic bool
 	kind     exprKind
+	sigs     []string
 }{
-	_Enum:  {"enum", 1, true, expression}, // 2 optional arguments
-	_Max:   {"max", 2, false, expression},
-	_Merge: {"merge", 2, true, expression}, // arbitrary arguments
-	_Min:   {"min", 2, false, expression},
-	_Not:   {"not", 1, false, expression},
-	_Zip:   {"zip", 3, false, expression},
+	_Compose:     {"compose", 2, false, expression, []string{"compose(f func(U) V, g func(T) U) func(T) V"}},
+	_Enum:        {"enum", 1, true, expression, []string{"enum(x T) []T", "enum(x, y T) []T", "enum(x, y, s T) []T"}}, // 2 optional arguments
+	_FromEntries: {"fromEntries", 1, false, expression, []string{"fromEntries(es []struct{Key T; Val U}) map[T]U"}},
+	_Max:         {"max", 2, false, expression, []string{"max(x, y T) T"}},
+	_Merge:       {"merge", 2, true, expression, []string{"merge(recv map[T]U, rest ...map[T]U) map[T]U"}}, // arbitrary arguments
+	_Min:         {"min", 2, false, expression, []string{"min(x, y T) T"}},
+	_Not:         {"not", 1, false, expression, []string{"not(fn T) T"}},
+	_Zip:         {"zip", 3, false, expression, []string{"zip(fn func(T, U) V, xs []T, ys []U) []V"}},
 }
 
 var predeclaredPlyMethods = [...]struct {
 	name     string
 	nargs    int
 	variadic bool
+	sigs     []string
 }{
-	_All:       {"all", 1, false},
-	_Any:       {"any", 1, false},
-	_Contains:  {"contains", 1, false},
-	_DropWhile: {"dropWhile", 1, false},
-	_Filter:    {"filter", 1, false},
-	_Fold:      {"fold", 1, true}, // 1 optional argument
-	_Morph:     {"morph", 1, false},
-	_Reverse:   {"reverse", 0, false},
-	_Sort:      {"sort", 0, true}, // 1 optional argument
-	_TakeWhile: {"takeWhile", 1, false},
-	_ToMap:     {"toMap", 1, false},
-	_ToSet:     {"toSet", 0, false},
+	_All:                 {"all", 1, false, []string{"([]T).all(pred func(T) bool) bool"}},
+	_Alli:                {"alli", 1, false, []string{"([]T).alli(pred func(int, T) bool) bool"}},
+	_Any:                 {"any", 1, false, []string{"([]T).any(pred func(T) bool) bool"}},
+	_Anyi:                {"anyi", 1, false, []string{"([]T).anyi(pred func(int, T) bool) bool"}},
+	_Argmax:              {"argmax", 0, false, []string{"([]T).argmax() int"}},
+	_Argmin:              {"argmin", 0, false, []string{"([]T).argmin() int"}},
+	_BinarySearch:        {"binarySearch", 1, true, []string{"([]T).binarySearch(e T) (int, bool)", "([]T).binarySearch(e T, less func(T, T) bool) (int, bool)"}}, // 1 optional argument
+	_Chunk:               {"chunk", 1, false, []string{"([]T).chunk(n int) [][]T"}},
+	_Contains:            {"contains", 1, false, []string{"([]T).contains(e T) bool", "(map[T]U).contains(e T) bool"}},
+	_Count:               {"count", 1, false, []string{"([]T).count(pred func(T) bool) int"}},
+	_CountBy:             {"countBy", 1, false, []string{"([]T).countBy(key func(T) U) map[U]int"}},
+	_DedupSorted:         {"dedupSorted", 0, true, []string{"([]T).dedupSorted() []T", "([]T).dedupSorted(less func(T, T) bool) []T"}}, // 1 optional argument
+	_Difference:          {"difference", 1, false, []string{"([]T).difference(other []T) []T", "(map[T]S).difference(other map[T]S) map[T]S"}},
+	_Drop:                {"drop", 1, false, []string{"([]T).drop(n int) []T"}},
+	_DropWhile:           {"dropWhile", 1, false, []string{"([]T).dropWhile(pred func(T) bool) []T"}},
+	_Elems:               {"elems", 0, false, []string{"(map[T]U).elems() []U"}},
+	_Entries:             {"entries", 0, false, []string{"(map[T]U).entries() []struct{Key T; Val U}"}},
+	_Equal:               {"equal", 1, false, []string{"([]T).equal(other []T) bool", "(map[T]S).equal(other map[T]S) bool"}},
+	_Filter:              {"filter", 1, false, []string{"([]T).filter(pred func(T) bool) []T", "(map[T]U).filter(pred func(T, U) bool) map[T]U"}},
+	_Filteri:             {"filteri", 1, false, []string{"([]T).filteri(pred func(int, T) bool) []T"}},
+	_Find:                {"find", 1, false, []string{"([]T).find(pred func(T) bool) (T, bool)"}},
+	_FindIndex:           {"findIndex", 1, false, []string{"([]T).findIndex(pred func(T) bool) int"}},
+	_FlatMorph:           {"flatMorph", 1, true, []string{"([]T).flatMorph(fn func(T) []U) []U", "([]T).flatMorph(fn func(T) []U, hint int) []U"}}, // 1 optional argument
+	_Flatten:             {"flatten", 0, true, []string{"([][]T).flatten() []T", "([][]T).flatten(hint int) []T"}},                                 // 1 optional argument
+	_Fold:                {"fold", 1, true, []string{"([]T).fold(fn func(T, T) T) T", "([]T).fold(fn func(U, T) U, acc U) U"}},                     // 1 optional argument
+	_Foldi:               {"foldi", 2, false, []string{"([]T).foldi(fn func(U, int, T) U, acc U) U"}},
+	_Foreach:             {"foreach", 1, false, []string{"([]T).foreach(fn func(T))"}},
+	_Foreachi:            {"foreachi", 1, false, []string{"([]T).foreachi(fn func(int, T))"}},
+	_GroupBy:             {"groupBy", 1, false, []string{"([]T).groupBy(key func(T) U) map[U][]T"}},
+	_IndexOf:             {"indexOf", 1, false, []string{"([]T).indexOf(e T) int"}},
+	_InsertSorted:        {"insertSorted", 1, true, []string{"([]T).insertSorted(e T) []T", "([]T).insertSorted(e T, less func(T, T) bool) []T"}}, // 1 optional argument
+	_Intersect:           {"intersect", 1, false, []string{"([]T).intersect(other []T) []T", "(map[T]S).intersect(other map[T]S) map[T]S"}},
+	_Invert:              {"invert", 0, false, []string{"(map[T]U).invert() map[U]T"}},
+	_IsSubset:            {"isSubset", 1, false, []string{"([]T).isSubset(other []T) bool", "(map[T]S).isSubset(other map[T]S) bool"}},
+	_IsSuperset:          {"isSuperset", 1, false, []string{"([]T).isSuperset(other []T) bool", "(map[T]S).isSuperset(other map[T]S) bool"}},
+	_Keys:                {"keys", 0, false, []string{"(map[T]U).keys() []T"}},
+	_LastIndexOf:         {"lastIndexOf", 1, false, []string{"([]T).lastIndexOf(e T) int"}},
+	_MapKeys:             {"mapKeys", 1, false, []string{"(map[T]U).mapKeys(fn func(T) V) map[V]U"}},
+	_MapValues:           {"mapValues", 1, false, []string{"(map[T]U).mapValues(fn func(U) V) map[T]V"}},
+	_MaxElem:             {"max", 0, false, []string{"([]T).max() (T, bool)"}},
+	_MaxBy:               {"maxBy", 1, false, []string{"([]T).maxBy(key func(T) U) (T, bool)"}},
+	_Mean:                {"mean", 0, false, []string{"([]T).mean() (float64, bool)"}},
+	_MergeSorted:         {"mergeSorted", 1, true, []string{"([]T).mergeSorted(other []T) []T", "([]T).mergeSorted(other []T, less func(T, T) bool) []T"}}, // 1 optional argument
+	_MinElem:             {"min", 0, false, []string{"([]T).min() (T, bool)"}},
+	_MinBy:               {"minBy", 1, false, []string{"([]T).minBy(key func(T) U) (T, bool)"}},
+	_Morph:               {"morph", 1, false, []string{"([]T).morph(fn func(T) U) []U", "(map[T]U).morph(fn func(T, U) (V, W)) map[V]W"}},
+	_Morphi:              {"morphi", 1, false, []string{"([]T).morphi(fn func(int, T) U) []U"}},
+	_Pairs:               {"pairs", 0, false, []string{"([]T).pairs() [][2]T"}},
+	_Partition:           {"partition", 1, false, []string{"([]T).partition(pred func(T) bool) (yes, no []T)"}},
+	_Product:             {"product", 0, false, []string{"([]T).product() T"}},
+	_Reverse:             {"reverse", 0, false, []string{"([]T).reverse() []T"}},
+	_Sort:                {"sort", 0, true, []string{"([]T).sort() []T", "([]T).sort(less func(T, T) bool) []T"}}, // 1 optional argument
+	_SortBy:              {"sortBy", 1, false, []string{"([]T).sortBy(key func(T) U) []T"}},
+	_SortDesc:            {"sortDesc", 0, false, []string{"([]T).sortDesc() []T"}},
+	_SortStable:          {"sortStable", 1, false, []string{"([]T).sortStable(less func(T, T) bool) []T"}},
+	_SortedEntries:       {"sortedEntries", 0, false, []string{"(map[T]U).sortedEntries() []struct{Key T; Val U}"}},
+	_Sum:                 {"sum", 0, false, []string{"([]T).sum() T"}},
+	_SymmetricDifference: {"symmetricDifference", 1, false, []string{"([]T).symmetricDifference(other []T) []T", "(map[T]S).symmetricDifference(other map[T]S) map[T]S"}},
+	_Take:                {"take", 1, false, []string{"([]T).take(n int) []T"}},
+	_TakeWhile:           {"takeWhile", 1, false, []string{"([]T).takeWhile(pred func(T) bool) []T"}},
+	_Tee:                 {"tee", 1, false, []string{"([]T).tee(fn func(T)) []T"}},
+	_ToMap:               {"toMap", 1, false, []string{"([]T).toMap(fn func(T) U) map[T]U"}},
+	_ToSet:               {"toSet", 0, false, []string{"([]T).toSet() map[T]struct{}"}},
+	_Union:               {"union", 1, false, []string{"([]T).union(other []T) []T", "(map[T]S).union(other map[T]S) map[T]S"}},
+	_Uniq:                {"uniq", 0, false, []string{"([]T).uniq() []T"}},
+	_Window:              {"window", 1, false, []string{"([]T).window(n int) [][]T"}},
+}
+
+// A PlyBuiltin describes one form of a ply function or method.
+type PlyBuiltin struct {
+	Name string // as written in Ply, e.g. "dropWhile"
+	Recv string // generic receiver type, e.g. "[]T"; empty for functions
+	Sig  string // generic signature, e.g. "([]T).dropWhile(pred func(T) bool) []T"
+}
+
+// PlyBuiltins returns each form of each ply function and method, as recorded
+// in the registry consulted by the type-checker. Functions are listed before
+// methods, and both are sorted by name.
+func PlyBuiltins() []PlyBuiltin {
+	var bs []PlyBuiltin
+	for _, f := range predeclaredPlyFuncs {
+		for _, sig := range f.sigs {
+			bs = append(bs, PlyBuiltin{Name: f.name, Sig: sig})
+		}
+	}
+	for _, m := range predeclaredPlyMethods {
+		for _, sig := range m.sigs {
+			recv := sig[1:strings.Index(sig, ").")]
+			bs = append(bs, PlyBuiltin{Name: m.name, Recv: recv, Sig: sig})
+		}
+	}
+	return bs
+}
+
+// plyMethodId returns the id of the ply method name, or false if there is
+// no such method.
+func plyMethodId(name string) (plyId, bool) {
+	for id, m := range predeclaredPlyMethods {
+		if name != "" && m.name == name {
+			return plyId(id), true
+		}
+	}
+	return 0, false
 }
 
 func defPredeclaredPlyFuncs() {
@@ -112,6 +277,33 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 	}
 
 	switch id {
+	case _Compose:
+		// compose(f func(U) V, g func(...) U) func(...) V
+
+		// g must be a function with a single return value
+		var y operand
+		arg(&y, 1)
+		if y.mode == invalid {
+			return
+		}
+		g, ok := y.typ.Underlying().(*Signature)
+		if !ok {
+			check.invalidArg(y.pos(), "cannot use %s as func(...) U value in argument to compose: %s is not a function", &y, y.typ)
+			return
+		} else if g.Results().Len() != 1 {
+			check.invalidArg(y.pos(), "cannot use %s as func(...) U value in argument to compose: it returns %s, want 1", &y, plural(g.Results().Len(), "value"))
+			return
+		}
+		U := g.Results().At(0).Type()
+
+		// f must be a function of U with a single return value
+		f := check.checkCallback(x, "compose", []callbackType{fixed(U)}, []callbackType{free("V")}, "")
+		if f == nil {
+			return
+		}
+		x.mode = value
+		x.typ = NewSignature(nil, g.Params(), f.Results(), g.Variadic())
+
 	case _Enum:
 		// enum(x, y, s T) []T
 		// enum(x, y T) []T
@@ -163,8 +355,8 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 	case _Merge:
 		// merge(x map[T]U, y ...map[T]U) map[T]U
 
-		// all args must have the same type, or nil
-		var T, U Type
+		// all args must be assignable to the type of the first, or nil
+		known := false
 		for i := range call.Args {
 			var y operand
 			arg(&y, i)
@@ -176,21 +368,17 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 				continue
 			}
 			// get type
-			t, ok := y.typ.Underlying().(*Map)
-			if !ok {
+			if _, ok := y.typ.Underlying().(*Map); !ok {
 				check.invalidArg(y.pos(), "merge expected map type; found %s", &y)
 				return
 			}
-			if T == nil {
-				// don't know T or U; set them
-				T, U = t.key, t.elem
+			if !known {
+				// set the type
+				known = true
 				x.typ = y.typ
-			} else {
-				// T and U are known
-				if !Identical(T, t.key) || !Identical(U, t.elem) {
-					check.invalidArg(y.pos(), "merge expected all args to be of type map[%s]%s; found %s", T, U, t)
-					return
-				}
+			} else if !y.assignableTo(check.conf, x.typ, nil) {
+				check.invalidArg(y.pos(), "merge expected all args to be assignable to %s; found %s", x.typ, &y)
+				return
 			}
 		}
 
@@ -199,6 +387,28 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 			//check.recordPlyType(call.Fun, makeSig(x.typ, x.typ, x.typ))
 		}
 
+	case _FromEntries:
+		// fromEntries(es []struct{Key T; Val U}) map[T]U
+		var st *Struct
+		if s, ok := x.typ.Underlying().(*Slice); ok {
+			st, _ = s.Elem().Underlying().(*Struct)
+		}
+		if st == nil || st.NumFields() != 2 || st.Field(0).Name() != "Key" || st.Field(1).Name() != "Val" {
+			check.invalidArg(x.pos(), "fromEntries expects []struct{Key T; Val U}; found %s", x)
+			return
+		}
+		// T must be a valid map key type
+		if T := st.Field(0).Type(); !Comparable(T) {
+			check.invalidArg(x.pos(), "invalid map key type %s", T)
+			return
+		}
+
+		x.mode = value
+		x.typ = NewMap(st.Field(0).Type(), st.Field(1).Type())
+		if check.Types != nil {
+			//check.recordPlyType(call.Fun, makeSig(x.typ, x.typ, x.typ))
+		}
+
 	case _Max, _Min:
 		// max(x, y T) T
 		// min(x, y T) T
@@ -260,8 +470,14 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 
 		// f must be a function with a single boolean return value
 		fn, ok := x.typ.Underlying().(*Signature)
-		if !ok || fn.Results().Len() != 1 || !Identical(fn.Results().At(0).Type(), Typ[Bool]) {
-			check.invalidArg(x.pos(), "cannot use %s as func(...) bool value in argument to not", x)
+		if !ok {
+			check.invalidArg(x.pos(), "cannot use %s as func(...) bool value in argument to not: %s is not a function", x, x.typ)
+			return
+		} else if fn.Results().Len() != 1 {
+			check.invalidArg(x.pos(), "cannot use %s as func(...) bool value in argument to not: it returns %s, want 1", x, plural(fn.Results().Len(), "value"))
+			return
+		} else if res := fn.Results().At(0).Type(); !Identical(res, Typ[Bool]) {
+			check.invalidArg(x.pos(), "cannot use %s as func(...) bool value in argument to not: its result has type %s, want bool", x, res)
 			return
 		}
 		x.mode = value
@@ -296,9 +512,8 @@ func (check *Checker) ply(x *operand, call *ast.CallExpr, id plyId) (_ bool) {
 		T := ts.Elem()
 		U := us.Elem()
 
-		fn, ok := x.typ.Underlying().(*Signature)
-		if !ok || fn.Results().Len() != 1 || !Identical(fn, makeSig(fn.Results().At(0).Type(), T, U)) {
-			check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) T value in argument to zip", x, T, U)
+		fn := check.checkCallback(x, "zip", []callbackType{fixed(T), fixed(U)}, []callbackType{free("V")}, "")
+		if fn == nil {
 			return
 		}
 		x.mode = value
@@ -353,23 +568,25 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 	}
 
 	switch id {
-	case _Contains:
+	case _Contains, _IndexOf, _LastIndexOf:
 		// NOTE: contains isn't all that special; we just want to give the
 		// user a nice message if they use a non-comparable type. If we tried
 		// to handle this in lookupPlyMethod, they'd just see "foo has no
-		// method contains".
+		// method contains". The same goes for indexOf and lastIndexOf.
 
 		switch recv := recv.Underlying().(type) {
 		case *Slice:
 			// ([]T).contains(T) bool
+			// ([]T).indexOf(T) int
+			// ([]T).lastIndexOf(T) int
 			T := recv.Elem()
-			check.assignment(x, T, check.sprintf("argument to contains"))
+			check.assignment(x, T, check.sprintf("argument to %s", bin.name))
 			if x.mode == invalid {
 				return
 			}
 			// T must be comparable or nil-able; if the latter, x must be nil
 			if !Comparable(T) && !hasNil(T) {
-				check.errorf(call.Pos(), "contains is only valid for comparable types (%s does not support ==)", T)
+				check.errorf(call.Pos(), "%s is only valid for comparable types (%s does not support ==)", bin.name, T)
 				return
 			} else if hasNil(T) && !x.isNil() {
 				check.invalidArg(x.pos(), "%s can only be compared to nil", T)
@@ -378,7 +595,7 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 
 		case *Map:
 			// (map[T]U).contains(T) bool
-			T := recv.Elem()
+			T := recv.Key()
 			check.assignment(x, T, check.sprintf("argument to contains"))
 			if x.mode == invalid {
 				return
@@ -388,7 +605,223 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 		}
 
 		x.mode = value
-		x.typ = Typ[Bool]
+		if id == _Contains {
+			x.typ = Typ[Bool]
+		} else {
+			x.typ = Typ[Int]
+		}
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _CountBy, _GroupBy:
+		// ([]T).countBy(func(T) U) map[U]int
+		// ([]T).groupBy(func(T) U) map[U][]T
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		name := predeclaredPlyMethods[id].name
+		fn := check.checkCallback(x, name, []callbackType{fixed(T)}, []callbackType{free("U")}, "")
+		if fn == nil {
+			return
+		}
+		// U must be a valid map key type
+		U := fn.Results().At(0).Type()
+		if !Comparable(U) {
+			check.invalidArg(x.pos(), "cannot use %s as key type in %s: %s is not a comparable type", U, name, U)
+			return
+		}
+
+		x.mode = value
+		if id == _CountBy {
+			x.typ = NewMap(U, Typ[Int])
+		} else {
+			x.typ = NewMap(U, NewSlice(T))
+		}
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _FlatMorph:
+		// ([]T).flatMorph(func(T) []U) []U
+		// ([]T).flatMorph(func(T) []U, int) []U
+		if nargs > 2 {
+			check.errorf(call.Pos(), "flatMorph expects 1 or 2 arguments; got %v", nargs)
+			return
+		}
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		fn := check.checkCallback(x, "flatMorph", []callbackType{fixed(T)}, []callbackType{free("[]U")}, "")
+		if fn == nil {
+			return
+		}
+		U, ok := fn.Results().At(0).Type().Underlying().(*Slice)
+		if !ok {
+			check.invalidArg(x.pos(), "cannot use %s as func(%s) []U value in argument to flatMorph: its result has type %s, want a slice; did you mean morph?", x, T, fn.Results().At(0).Type())
+			return
+		}
+
+		// capacity hint is optional
+		if nargs == 2 {
+			var hint operand
+			arg(&hint, 1)
+			if hint.mode == invalid {
+				return
+			}
+			check.assignment(&hint, Typ[Int], "argument to flatMorph")
+			if hint.mode == invalid {
+				return
+			}
+		}
+
+		x.mode = value
+		x.typ = NewSlice(U.Elem())
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Flatten:
+		// ([][]T).flatten() []T
+		// ([][]T).flatten(int) []T
+		if nargs > 1 {
+			check.errorf(call.Pos(), "flatten expects 0 or 1 arguments; got %v", nargs)
+			return
+		}
+		T := recv.Underlying().(*Slice).Elem().Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+
+		// capacity hint is optional
+		if nargs == 1 {
+			check.assignment(x, Typ[Int], "argument to flatten")
+			if x.mode == invalid {
+				return
+			}
+		}
+
+		x.mode = value
+		x.typ = NewSlice(T)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _MaxElem, _MinElem, _Argmax, _Argmin:
+		// ([]T).max() (T, bool)
+		// ([]T).min() (T, bool)
+		// ([]T).argmax() int
+		// ([]T).argmin() int
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if !isOrdered(T) {
+			check.errorf(call.Rparen, "%s is only valid for ordered types (%s does not support <)", bin.name, T)
+			return
+		}
+
+		x.mode = value
+		if id == _Argmax || id == _Argmin {
+			x.typ = Typ[Int]
+		} else {
+			x.typ = NewTuple(
+				NewVar(token.NoPos, nil, "", T),
+				NewVar(token.NoPos, nil, "", Typ[Bool]),
+			)
+		}
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _MaxBy, _MinBy:
+		// ([]T).maxBy(func(T) U) (T, bool)
+		// ([]T).minBy(func(T) U) (T, bool)
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		fn := check.checkCallback(x, bin.name, []callbackType{fixed(T)}, []callbackType{free("U")}, "")
+		if fn == nil {
+			return
+		}
+		// the key type must support <
+		if U := fn.Results().At(0).Type(); !isOrdered(U) {
+			check.invalidArg(x.pos(), "cannot use %s as key type in %s: %s is not an ordered type", U, bin.name, U)
+			return
+		}
+
+		x.mode = value
+		x.typ = NewTuple(
+			NewVar(token.NoPos, nil, "", T),
+			NewVar(token.NoPos, nil, "", Typ[Bool]),
+		)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Mean:
+		// ([]T).mean() (float64, bool)
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if !isNumeric(T) || isComplex(T) {
+			check.errorf(call.Rparen, "mean is only valid for integer and floating-point types (%s is neither)", T)
+			return
+		}
+
+		x.mode = value
+		x.typ = NewTuple(
+			NewVar(token.NoPos, nil, "", Typ[Float64]),
+			NewVar(token.NoPos, nil, "", Typ[Bool]),
+		)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Partition:
+		// ([]T).partition(func(T) bool) ([]T, []T)
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if check.checkCallback(x, "partition", []callbackType{fixed(T)}, []callbackType{fixed(Typ[Bool])}, "") == nil {
+			return
+		}
+
+		x.mode = value
+		x.typ = NewTuple(
+			NewVar(token.NoPos, nil, "", NewSlice(T)),
+			NewVar(token.NoPos, nil, "", NewSlice(T)),
+		)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Find:
+		// ([]T).find(func(T) bool) (T, bool)
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if check.checkCallback(x, "find", []callbackType{fixed(T)}, []callbackType{fixed(Typ[Bool])}, "") == nil {
+			return
+		}
+
+		x.mode = value
+		x.typ = NewTuple(
+			NewVar(token.NoPos, nil, "", T),
+			NewVar(token.NoPos, nil, "", Typ[Bool]),
+		)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Foldi:
+		// ([]T).foldi(func(U, int, T) U, U) U
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		var hint string
+		if numParams(x) == 2 {
+			hint = "did you mean fold?"
+		}
+		fn := check.checkCallback(x, "foldi", []callbackType{free("U"), fixed(Typ[Int]), fixed(T)}, []callbackType{free("U")}, hint)
+		if fn == nil {
+			return
+		}
+		U := fn.Results().At(0).Type()
+
+		// initial value is mandatory
+		var y operand
+		arg(&y, 1)
+		if y.mode == invalid {
+			return
+		}
+		check.assignment(&y, U, "initial value of foldi")
+		if y.mode == invalid {
+			return
+		}
+
+		x.mode = value
+		x.typ = U
 		if check.Types != nil {
 			// TODO: record here?
 		}
@@ -401,16 +834,15 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			return
 		}
 		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
-		fn, ok := x.typ.Underlying().(*Signature)
-		if !ok || fn.Params().Len() != 2 || fn.Results().Len() != 1 {
-			check.invalidArg(x.pos(), "cannot use %s as func(T, %s) T value in argument to fold", x, T)
-			return
+		var hint string
+		if numParams(x) == 3 {
+			hint = "did you mean foldi?"
 		}
-		U := fn.Results().At(0).Type()
-		if !Identical(fn.Params().At(0).Type(), U) || !Identical(fn.Params().At(1).Type(), T) || !Identical(fn.Results().At(0).Type(), U) {
-			check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) %s value in argument to fold", x, U, T, U)
+		fn := check.checkCallback(x, "fold", []callbackType{free("U"), fixed(T)}, []callbackType{free("U")}, hint)
+		if fn == nil {
 			return
 		}
+		U := fn.Results().At(0).Type()
 
 		// initial value is optional
 		if nargs == 2 {
@@ -419,20 +851,14 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			if y.mode == invalid {
 				return
 			}
-			if isUntyped(y.typ) {
-				// y may be untyped; convert to U
-				check.convertUntyped(&y, U)
-				if y.mode == invalid {
-					return
-				}
-			} else if !Identical(y.typ, U) {
-				check.invalidArg(y.pos(), "cannot use %s as initial %s value of fold func(%s, %s) %s", &y, U, U, T, U)
+			check.assignment(&y, U, "initial value of fold")
+			if y.mode == invalid {
 				return
 			}
 		} else {
 			// if no initial value is provided, then T and U must be identical
 			if !Identical(T, U) {
-				check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) %s value in argument to fold", x, T, T, T)
+				check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) %s value in argument to fold: its result has type %s, want %s; did you mean to pass an initial %s value, as in fold(fn, init)?", x, T, T, T, U, T, U)
 				return
 			}
 		}
@@ -443,14 +869,35 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			// TODO: record here?
 		}
 
+	case _Morphi:
+		// ([]T).morphi(func(int, T) U) []U
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		var hint string
+		if numParams(x) == 1 {
+			hint = "did you mean morph?"
+		}
+		fn := check.checkCallback(x, "morphi", []callbackType{fixed(Typ[Int]), fixed(T)}, []callbackType{free("U")}, hint)
+		if fn == nil {
+			return
+		}
+
+		x.mode = value
+		x.typ = NewSlice(fn.Results().At(0).Type())
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
 	case _Morph:
 		switch recv := recv.Underlying().(type) {
 		case *Slice:
 			// ([]T).morph(func(T) U) []U
 			T := recv.Elem()
-			fn, ok := x.typ.Underlying().(*Signature)
-			if !ok || fn.Params().Len() != 1 || fn.Results().Len() != 1 || !Identical(fn.Params().At(0).Type(), T) {
-				check.invalidArg(x.pos(), "cannot use %s as func(%s) T value in argument to morph", x, T)
+			var hint string
+			if numParams(x) == 2 {
+				hint = "did you mean morphi?"
+			}
+			fn := check.checkCallback(x, "morph", []callbackType{fixed(T)}, []callbackType{free("U")}, hint)
+			if fn == nil {
 				return
 			}
 
@@ -463,9 +910,17 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 		case *Map:
 			// (map[T]U).morph(func(T, U) (V, W) map[V]W
 			T, U := recv.Key(), recv.Elem()
-			fn, ok := x.typ.Underlying().(*Signature)
-			if !ok || fn.Params().Len() != 2 || fn.Results().Len() != 2 || !Identical(fn.Params().At(0).Type(), T) || !Identical(fn.Params().At(1).Type(), U) {
-				check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) (T, U) value in argument to morph", x, T, U)
+			var hint string
+			if fn, ok := x.typ.Underlying().(*Signature); ok && fn.Params().Len() == 1 && fn.Results().Len() == 1 {
+				// a function of the keys or the values alone
+				if Identical(fn.Params().At(0).Type(), T) {
+					hint = "did you mean mapKeys?"
+				} else if Identical(fn.Params().At(0).Type(), U) {
+					hint = "did you mean mapValues?"
+				}
+			}
+			fn := check.checkCallback(x, "morph", []callbackType{fixed(T), fixed(U)}, []callbackType{free("V"), free("W")}, hint)
+			if fn == nil {
 				return
 			}
 			V := fn.Results().At(0).Type()
@@ -486,6 +941,135 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			unreachable()
 		}
 
+	case _Invert:
+		// (map[T]U).invert() map[U]T
+		m := recv.Underlying().(*Map) // enforced by lookupPlyMethod
+		if !Comparable(m.Elem()) {
+			check.errorf(call.Pos(), "cannot invert %s: %s is not a comparable type", recv, m.Elem())
+			return
+		}
+
+		x.mode = value
+		x.typ = NewMap(m.Elem(), m.Key())
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _MapKeys:
+		// (map[T]U).mapKeys(func(T) V) map[V]U
+		m := recv.Underlying().(*Map) // enforced by lookupPlyMethod
+		T, U := m.Key(), m.Elem()
+		var hint string
+		if numParams(x) == 2 {
+			hint = "did you mean morph?"
+		}
+		fn := check.checkCallback(x, "mapKeys", []callbackType{fixed(T)}, []callbackType{free("V")}, hint)
+		if fn == nil {
+			return
+		}
+		// V must be a valid map key type
+		V := fn.Results().At(0).Type()
+		if !Comparable(V) {
+			check.invalidArg(x.pos(), "cannot map key type %s to %s: %s is not a comparable type", T, V, V)
+			return
+		}
+
+		x.mode = value
+		x.typ = NewMap(V, U)
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _MapValues:
+		// (map[T]U).mapValues(func(U) V) map[T]V
+		m := recv.Underlying().(*Map) // enforced by lookupPlyMethod
+		T, U := m.Key(), m.Elem()
+		var hint string
+		if numParams(x) == 2 {
+			hint = "did you mean morph?"
+		}
+		fn := check.checkCallback(x, "mapValues", []callbackType{fixed(U)}, []callbackType{free("V")}, hint)
+		if fn == nil {
+			return
+		}
+
+		x.mode = value
+		x.typ = NewMap(T, fn.Results().At(0).Type())
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _SortedEntries:
+		// (map[T]U).sortedEntries() []struct{Key T; Val U}
+		m := recv.Underlying().(*Map) // enforced by lookupPlyMethod
+		if !isOrdered(m.Key()) {
+			check.errorf(call.Rparen, "cannot sort entries of %s: %s is not an ordered type", recv, m.Key())
+			return
+		}
+
+		x.mode = value
+		x.typ = NewSlice(plyEntry(m.Key(), m.Elem()))
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _BinarySearch, _DedupSorted, _InsertSorted, _MergeSorted:
+		// ([]T).binarySearch(T) (int, bool)
+		// ([]T).binarySearch(T, func(T, T) bool) (int, bool)
+		// ([]T).dedupSorted() []T
+		// ([]T).dedupSorted(func(T, T) bool) []T
+		// ([]T).insertSorted(T) []T
+		// ([]T).insertSorted(T, func(T, T) bool) []T
+		// ([]T).mergeSorted([]T) []T
+		// ([]T).mergeSorted([]T, func(T, T) bool) []T
+		if nargs > bin.nargs+1 {
+			check.errorf(call.Pos(), "%s expects %d or %d arguments; got %v", bin.name, bin.nargs, bin.nargs+1, nargs)
+			return
+		}
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+
+		switch id {
+		case _BinarySearch, _InsertSorted:
+			check.assignment(x, T, check.sprintf("argument to %s", bin.name))
+		case _MergeSorted:
+			check.assignment(x, recv, "argument to mergeSorted")
+		}
+		if x.mode == invalid {
+			return
+		}
+
+		// lessfn is optional
+		if nargs == bin.nargs+1 {
+			less := x
+			if bin.nargs > 0 {
+				less = new(operand)
+				arg(less, bin.nargs)
+				if less.mode == invalid {
+					return
+				}
+			}
+			if check.checkCallback(less, bin.name, []callbackType{fixed(T), fixed(T)}, []callbackType{fixed(Typ[Bool])}, "") == nil {
+				return
+			}
+		} else if !isOrdered(T) {
+			// without a lessfn, T must support <
+			check.errorf(call.Rparen, "cannot use %s on %s without a less function: %s is not an ordered type", bin.name, recv, T)
+			return
+		}
+
+		x.mode = value
+		if id == _BinarySearch {
+			x.typ = NewTuple(
+				NewVar(token.NoPos, nil, "", Typ[Int]),
+				NewVar(token.NoPos, nil, "", Typ[Bool]),
+			)
+		} else {
+			x.typ = recv
+		}
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
 	case _Sort:
 		// ([]T).sort() []T
 		// ([]T).sort(func(T, T) bool) []T
@@ -501,10 +1085,17 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			if x.mode == invalid {
 				return
 			}
-			if !Identical(x.typ, makeSig(Typ[Bool], T, T)) {
-				check.invalidArg(x.pos(), "cannot use %s as func(%s, %s) bool value in argument to sort", x, T, T)
+			var hint string
+			if numParams(x) == 1 {
+				hint = "did you mean sortBy?"
+			}
+			if check.checkCallback(x, "sort", []callbackType{fixed(T), fixed(T)}, []callbackType{fixed(Typ[Bool])}, hint) == nil {
 				return
 			}
+		} else if !isOrdered(T) {
+			// without a sortfn, T must support <
+			check.errorf(call.Rparen, "cannot sort %s without a less function: %s is not an ordered type", recv, T)
+			return
 		}
 		x.mode = value
 		x.typ = recv
@@ -512,12 +1103,94 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 			// TODO: record here?
 		}
 
+	case _SortBy:
+		// ([]T).sortBy(func(T) U) []T
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		var hint string
+		if numParams(x) == 2 {
+			hint = "did you mean sort?"
+		}
+		fn := check.checkCallback(x, "sortBy", []callbackType{fixed(T)}, []callbackType{free("U")}, hint)
+		if fn == nil {
+			return
+		}
+		// the key type must support <
+		if U := fn.Results().At(0).Type(); !isOrdered(U) {
+			check.invalidArg(x.pos(), "cannot sort by %s: %s is not an ordered type", U, U)
+			return
+		}
+
+		x.mode = value
+		x.typ = recv
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _SortDesc:
+		// ([]T).sortDesc() []T
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if !isOrdered(T) {
+			check.errorf(call.Rparen, "cannot sort %s in descending order: %s is not an ordered type", recv, T)
+			return
+		}
+
+		x.mode = value
+		x.typ = recv
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Sum, _Product:
+		// ([]T).sum() T
+		// ([]T).product() T
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if !isNumeric(T) {
+			check.errorf(call.Rparen, "%s is only valid for numeric types (%s is not numeric)", bin.name, T)
+			return
+		}
+
+		x.mode = value
+		x.typ = T
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
+	case _Union, _Intersect, _Difference, _SymmetricDifference, _IsSubset, _IsSuperset, _Equal:
+		// ([]T).union([]T) []T
+		// ([]T).intersect([]T) []T
+		// ([]T).difference([]T) []T
+		// ([]T).symmetricDifference([]T) []T
+		// ([]T).isSubset([]T) bool
+		// ([]T).isSuperset([]T) bool
+		// ([]T).equal([]T) bool
+		//
+		// NOTE: the map forms are not special; see plyMethods.
+		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
+		if !Comparable(T) {
+			check.errorf(call.Pos(), "%s is only valid for comparable types (%s does not support ==)", bin.name, T)
+			return
+		}
+		check.assignment(x, recv, check.sprintf("argument to %s", bin.name))
+		if x.mode == invalid {
+			return
+		}
+
+		x.mode = value
+		switch id {
+		case _IsSubset, _IsSuperset, _Equal:
+			x.typ = Typ[Bool]
+		default:
+			x.typ = recv
+		}
+		if check.Types != nil {
+			// TODO: record here?
+		}
+
 	case _ToMap:
 		// ([]T).toMap(func(T) U) map[T]U
 		T := recv.Underlying().(*Slice).Elem() // enforced by lookupPlyMethod
-		fn, ok := x.typ.Underlying().(*Signature)
-		if !ok || fn.Params().Len() != 1 || fn.Results().Len() != 1 || !Identical(fn.Params().At(0).Type(), T) {
-			check.invalidArg(x.pos(), "cannot use %s as func(%s) T value in argument to toMap", x, T)
+		fn := check.checkCallback(x, "toMap", []callbackType{fixed(T)}, []callbackType{free("U")}, "")
+		if fn == nil {
 			return
 		}
 
@@ -534,92 +1207,493 @@ func (check *Checker) plySpecialMethod(x *operand, call *ast.CallExpr, recv Type
 	return true
 }
 
+// A callbackType is a parameter or result type in the signature expected of
+// a callback. If typ is nil, the type is the type parameter name, which
+// matches any type, but must match the same type wherever it appears.
+type callbackType struct {
+	typ  Type
+	name string
+}
+
+// fixed returns a callbackType that matches only typ.
+func fixed(typ Type) callbackType { return callbackType{typ: typ} }
+
+// free returns a callbackType for the type parameter name.
+func free(name string) callbackType { return callbackType{name: name} }
+
+// checkCallback checks that x is assignable to a function type with the
+// signature described by params and results, and returns its signature. As
+// with any assignment, x may be of a named function type. Otherwise, it
+// reports an error stating the expected signature (with any type parameters
+// that x determines filled in) and how x differs from it, followed by hint,
+// if any, and returns nil.
+func (check *Checker) checkCallback(x *operand, name string, params, results []callbackType, hint string) *Signature {
+	fn, bound, mismatch := check.callbackMismatch(x.typ, params, results)
+	if mismatch == "" {
+		sig := NewSignature(nil, fn.Params(), fn.Results(), false)
+		if x.assignableTo(check.conf, sig, nil) {
+			return fn
+		}
+		mismatch = check.sprintf("%s is not assignable to %s", x.typ, sig)
+	}
+	msg := check.sprintf("cannot use %s as %s value in argument to %s: %s", x, check.callbackString(params, results, bound), name, mismatch)
+	if hint != "" {
+		msg += "; " + hint
+	}
+	check.invalidArg(x.pos(), "%s", msg)
+	return nil
+}
+
+// callbackMismatch returns a description of how typ differs from the
+// signature described by params and results, along with the types bound to
+// type parameters. The description is empty if typ matches.
+func (check *Checker) callbackMismatch(typ Type, params, results []callbackType) (*Signature, map[string]Type, string) {
+	bound := make(map[string]Type)
+	fn, ok := typ.Underlying().(*Signature)
+	if !ok {
+		return nil, bound, check.sprintf("%s is not a function", typ)
+	}
+	if fn.Params().Len() != len(params) {
+		return nil, bound, fmt.Sprintf("it has %s, want %d", plural(fn.Params().Len(), "parameter"), len(params))
+	} else if fn.Results().Len() != len(results) {
+		return nil, bound, fmt.Sprintf("it returns %s, want %d", plural(fn.Results().Len(), "value"), len(results))
+	} else if fn.Variadic() {
+		return nil, bound, "it is variadic"
+	}
+	match := func(want callbackType, have Type, what string) string {
+		if want.typ == nil {
+			if b, ok := bound[want.name]; ok {
+				want.typ = b
+			} else {
+				bound[want.name] = have
+				return ""
+			}
+		}
+		if !Identical(have, want.typ) {
+			return check.sprintf("%s has type %s, want %s", what, have, want.typ)
+		}
+		return ""
+	}
+	// match the results first, so that a type parameter shared by a
+	// parameter and a result (e.g. the accumulator of fold) is reported as
+	// a mismatched parameter
+	for i, want := range results {
+		if m := match(want, fn.Results().At(i).Type(), ordinal(i, len(results), "result")); m != "" {
+			return nil, bound, m
+		}
+	}
+	for i, want := range params {
+		if m := match(want, fn.Params().At(i).Type(), ordinal(i, len(params), "parameter")); m != "" {
+			return nil, bound, m
+		}
+	}
+	return fn, bound, ""
+}
+
+// callbackString returns the signature described by params and results, with
+// bound type parameters replaced by their types.
+func (check *Checker) callbackString(params, results []callbackType, bound map[string]Type) string {
+	str := func(ts []callbackType) []string {
+		strs := make([]string, len(ts))
+		for i, t := range ts {
+			if t.typ == nil {
+				t.typ = bound[t.name]
+			}
+			if t.typ != nil {
+				strs[i] = check.sprintf("%s", t.typ)
+			} else {
+				strs[i] = t.name
+			}
+		}
+		return strs
+	}
+	sig := "func(" + strings.Join(str(params), ", ") + ")"
+	switch rs := str(results); len(rs) {
+	case 0:
+	case 1:
+		sig += " " + rs[0]
+	default:
+		sig += " (" + strings.Join(rs, ", ") + ")"
+	}
+	return sig
+}
+
+// plyCallbackArgument checks that x is a function with the signature want,
+// as required by the ply method sel, reporting an error if not. It suggests
+// the index-aware variant of the method (or vice versa) if x has one
+// parameter too many (or too few).
+func (check *Checker) plyCallbackArgument(x *operand, sel *ast.SelectorExpr, want *Signature) *Signature {
+	name := sel.Sel.Name
+	var hint string
+	switch numParams(x) {
+	case want.Params().Len() + 1:
+		if IsPlyMethod(name + "i") {
+			hint = "did you mean " + name + "i?"
+		}
+	case want.Params().Len() - 1:
+		if strings.HasSuffix(name, "i") && IsPlyMethod(strings.TrimSuffix(name, "i")) {
+			hint = "did you mean " + strings.TrimSuffix(name, "i") + "?"
+		}
+	}
+	fixedTuple := func(t *Tuple) []callbackType {
+		ts := make([]callbackType, t.Len())
+		for i := range ts {
+			ts[i] = fixed(t.At(i).Type())
+		}
+		return ts
+	}
+	return check.checkCallback(x, check.sprintf("%s", ast.Expr(sel)), fixedTuple(want.Params()), fixedTuple(want.Results()), hint)
+}
+
+// numParams returns the number of parameters of the function x, or -1 if x is
+// not a function.
+func numParams(x *operand) int {
+	if fn, ok := x.typ.Underlying().(*Signature); ok {
+		return fn.Params().Len()
+	}
+	return -1
+}
+
+// plural returns n followed by noun, pluralized if necessary.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// ordinal returns a description of the ith of n parameters or results, e.g.
+// "its second parameter".
+func ordinal(i, n int, noun string) string {
+	if n == 1 {
+		return "its " + noun
+	}
+	if i < 3 {
+		return "its " + [...]string{"first", "second", "third"}[i] + " " + noun
+	}
+	return fmt.Sprintf("its %s %d", noun, i+1)
+}
+
 // lookupPlyMethod returns the ply method 'name' if it exists for T. Some ply
 // methods are special; specifically, their signature depends on their
 // arguments. In this case, a special sentinel signature is returned instead
 // of the typical full signature. These calls will be handled later by
 // plySpecialMethod.
+//
+// lookupPlyMethod is only consulted if T has no field or method named 'name',
+// so the fields and methods declared by the program always take precedence
+// over ply methods. This includes methods with pointer receivers, even when
+// the receiver is not addressable (in which case the call is an error, as it
+// would be in Go). Ply methods are created without a package or receiver,
+// which is how later stages distinguish them from declared methods.
+//
+// Like declared methods, ply methods are promoted from embedded fields, so a
+// struct embedding a named slice or map type has the ply methods of that
+// type. The embedded fields are searched breadth-first, as in
+// lookupFieldOrMethod; the last entry of the returned index is the ply id,
+// and the earlier entries are the indices of the fields traversed to reach
+// the receiver. Since no field or method named 'name' exists at any depth,
+// the only possible collision is between ply methods at the same depth.
 func lookupPlyMethod(T Type, name string) (obj Object, index []int, indirect bool) {
-	type method struct {
-		args    []Type
-		ret     Type
-		special bool
+	id, ok := plyMethodId(name)
+	if !ok {
+		// not a ply method
+		return nil, nil, false
+	}
+
+	typ, isPtr := deref(T)
+	named, _ := typ.(*Named)
+	current := []embeddedType{{named, nil, isPtr, false}}
+	var seen map[*Named]bool
+	for depth := 0; len(current) > 0; depth++ {
+		var next []embeddedType
+		for _, e := range current {
+			recv, utyp := T, typ.Underlying()
+			if depth > 0 {
+				recv, utyp = e.typ, e.typ.underlying
+			}
+			if e.typ != nil {
+				if seen[e.typ] {
+					continue // shadowed by the same type at a shallower depth
+				}
+				if seen == nil {
+					seen = make(map[*Named]bool)
+				}
+				seen[e.typ] = true
+			}
+
+			if m, found := plyMethods(recv)[name]; found {
+				index = concat(e.index, int(id))
+				if obj != nil || e.multiples {
+					return nil, index, false // collision
+				}
+				if m.special {
+					obj = makeSpecialPlyMethod(id, recv)
+				} else {
+					obj = makePlyMethod(id, m.ret, m.args...)
+				}
+				indirect = e.indirect
+				continue
+			}
+
+			// collect embedded fields for searching the next depth
+			if t, _ := utyp.(*Struct); t != nil && obj == nil {
+				for i, f := range t.fields {
+					if !f.anonymous {
+						continue
+					}
+					typ, isPtr := deref(f.typ)
+					if t, _ := typ.(*Named); t != nil {
+						next = append(next, embeddedType{t, concat(e.index, i), e.indirect || isPtr, e.multiples})
+					}
+				}
+			}
+		}
+		if obj != nil {
+			return
+		}
+		current = consolidateMultiples(next)
 	}
-	var methods map[string]method
+
+	// not a ply method
+	return nil, nil, false
+}
+
+// A plyMethod describes the signature of a ply method. The signature of a
+// special method is determined by plySpecialMethod.
+type plyMethod struct {
+	args    []Type
+	ret     Type
+	special bool
+}
+
+// plyMethods returns the ply methods of T, keyed by name. The signatures of
+// ordinary methods are read from the registry.
+func plyMethods(T Type) map[string]plyMethod {
+	methods := make(map[string]plyMethod)
 	switch t := T.Underlying().(type) {
 	case *Slice:
-		side := makeSig(nil, t.Elem())       // func(T)
-		pred := makeSig(Typ[Bool], t.Elem()) // func(T) bool
-		empty := NewStruct(nil, nil)         // struct{}
-		methods = map[string]method{
-			"all":       {[]Type{pred}, Typ[Bool], false},      // ([]T).all(func(T) bool) bool
-			"any":       {[]Type{pred}, Typ[Bool], false},      // ([]T).any(func(T) bool) bool
-			"drop":      {[]Type{Typ[Int]}, T, false},          // ([]T).drop(int) []T
-			"dropWhile": {[]Type{pred}, T, false},              // ([]T).dropWhile(func(T) bool) []T
-			"filter":    {[]Type{pred}, T, false},              // ([]T).filter(func(T) bool) []T
-			"foreach":   {[]Type{side}, nil, false},            // ([]T).foreach(func(T))
-			"reverse":   {nil, T, false},                       // ([]T).reverse() []T
-			"take":      {[]Type{Typ[Int]}, T, false},          // ([]T).take(int) []T
-			"takeWhile": {[]Type{pred}, T, false},              // ([]T).takeWhile(func(T) bool) []T
-			"tee":       {[]Type{side}, T, false},              // ([]T).tee(func(T)) []T
-			"toSet":     {nil, NewMap(t.Elem(), empty), false}, // ([]T).toSet() map[T]struct{}
-			"uniq":      {nil, T, false},                       // ([]T).uniq() []T
-
-			// special methods
-			"contains": {nil, nil, true}, // ([]T).contains(T) bool
-			"fold":     {nil, nil, true}, // ([]T).fold(func(U, T) U, U) U
-			"morph":    {nil, nil, true}, // ([]T).morph(func(T) U) []U
-			"sort":     {nil, nil, true}, // ([]T).sort(func(T, T) bool) []T
-			"toMap":    {nil, nil, true}, // ([]T).toMap(func(T) U) map[T]U
+		addPlyMethods(methods, T, "[]T", map[string]Type{"T": t.Elem()})
+		// methods specific to slices of slices
+		if s, ok := t.Elem().Underlying().(*Slice); ok {
+			addPlyMethods(methods, T, "[][]T", map[string]Type{"T": s.Elem()})
 		}
 
 	case *Map:
-		pred := makeSig(Typ[Bool], t.Key(), t.Elem()) // func(T, U) bool
-		methods = map[string]method{
-			"elems":  {nil, NewSlice(t.Elem()), false}, // (map[T]U).elems() []U
-			"filter": {[]Type{pred}, T, false},         // (map[T]U].filter(func(T, U) bool) map[T]U
-			"keys":   {nil, NewSlice(t.Key()), false},  // (map[T]U).keys() []T
+		addPlyMethods(methods, T, "map[T]U", map[string]Type{"T": t.Key(), "U": t.Elem()})
+		// methods specific to sets, i.e. map[T]struct{} and map[T]bool
+		if isSetElem(t.Elem()) {
+			addPlyMethods(methods, T, "map[T]S", map[string]Type{"T": t.Key(), "S": t.Elem()})
+		}
+	}
+	return methods
+}
 
-			// special methods
-			"contains": {nil, nil, true}, // (map[T]U).contains(T) bool
-			"morph":    {nil, nil, true}, // (map[T]U).morph(func(T, U) (V, W)) map[V]W
+// specialPlyMethods lists, for each receiver type in the registry, the
+// methods whose signatures depend on their arguments. These are checked by
+// plySpecialMethod rather than read from the registry.
+var specialPlyMethods = map[string]map[string]bool{
+	"[]T": {
+		"argmax": true, "argmin": true, "binarySearch": true, "contains": true,
+		"countBy": true, "dedupSorted": true, "difference": true, "equal": true,
+		"find": true, "flatMorph": true, "fold": true, "foldi": true,
+		"groupBy": true, "indexOf": true, "insertSorted": true, "intersect": true,
+		"isSubset": true, "isSuperset": true, "lastIndexOf": true, "max": true,
+		"maxBy": true, "mean": true, "mergeSorted": true, "min": true,
+		"minBy": true, "morph": true, "morphi": true, "partition": true,
+		"product": true, "sort": true, "sortBy": true, "sortDesc": true,
+		"sum": true, "symmetricDifference": true, "toMap": true, "union": true,
+	},
+	"[][]T": {
+		"flatten": true,
+	},
+	"map[T]U": {
+		"contains": true, "invert": true, "mapKeys": true, "mapValues": true,
+		"morph": true, "sortedEntries": true,
+	},
+	"map[T]S": {},
+}
+
+// addPlyMethods adds to methods each registry method of the receiver type
+// recv, e.g. "[]T", with the type parameters of recv bound to the types in
+// params. Wherever recv itself appears as an argument or result, T is
+// substituted for it, so that named types are preserved.
+func addPlyMethods(methods map[string]plyMethod, T Type, recv string, params map[string]Type) {
+	for _, m := range predeclaredPlyMethods {
+		for _, sig := range m.sigs {
+			if !strings.HasPrefix(sig, "("+recv+")."+m.name+"(") {
+				continue
+			}
+			if specialPlyMethods[recv][m.name] {
+				methods[m.name] = plyMethod{nil, nil, true}
+				break
+			}
+			typ := func(e ast.Expr) Type {
+				if ExprString(e) == recv {
+					return T
+				}
+				return registryType(e, params)
+			}
+			fn := plyMethodSigs[sig]
+			var args []Type
+			for _, f := range fn.Params.List {
+				for range f.Names {
+					args = append(args, typ(f.Type))
+				}
+			}
+			var ret Type
+			if fn.Results != nil {
+				if len(fn.Results.List) != 1 || len(fn.Results.List[0].Names) > 1 {
+					panic("ordinary ply method with multiple results: " + sig)
+				}
+				ret = typ(fn.Results.List[0].Type)
+			}
+			methods[m.name] = plyMethod{args, ret, false}
+			break
 		}
 	}
+}
 
-	if m, ok := methods[name]; ok {
-		if m.special {
-			return makeSpecialPlyMethod(name, T)
+// plyMethodSigs holds the parsed registry signatures of the ply methods,
+// keyed by signature.
+var plyMethodSigs = func() map[string]*ast.FuncType {
+	sigs := make(map[string]*ast.FuncType)
+	for _, m := range predeclaredPlyMethods {
+		for _, sig := range m.sigs {
+			i := strings.Index(sig, ")."+m.name+"(")
+			if i < 0 {
+				panic("malformed registry signature: " + sig)
+			}
+			e, err := parser.ParseExpr("func" + sig[i+len(")."+m.name):])
+			if err != nil {
+				panic("malformed registry signature: " + sig)
+			}
+			sigs[sig] = e.(*ast.FuncType)
 		}
-		return makePlyMethod(name, m.ret, m.args...)
 	}
+	return sigs
+}()
 
-	// not a ply method
-	return nil, nil, false
+// registryType returns the type denoted by the registry type expression e,
+// with the type parameters bound to the types in params.
+func registryType(e ast.Expr, params map[string]Type) Type {
+	switch e := e.(type) {
+	case *ast.Ident:
+		if typ, ok := params[e.Name]; ok {
+			return typ
+		}
+		if obj, ok := Universe.Lookup(e.Name).(*TypeName); ok {
+			return obj.Type()
+		}
+	case *ast.ArrayType:
+		elem := registryType(e.Elt, params)
+		if e.Len == nil {
+			return NewSlice(elem)
+		}
+		if lit, ok := e.Len.(*ast.BasicLit); ok && lit.Kind == token.INT {
+			if n, err := strconv.ParseInt(lit.Value, 10, 64); err == nil {
+				return NewArray(elem, n)
+			}
+		}
+	case *ast.MapType:
+		return NewMap(registryType(e.Key, params), registryType(e.Value, params))
+	case *ast.FuncType:
+		var args, results []*Var
+		for _, f := range e.Params.List {
+			typ := registryType(f.Type, params)
+			for range f.Names {
+				args = append(args, NewParam(token.NoPos, nil, "", typ))
+			}
+			if len(f.Names) == 0 {
+				args = append(args, NewParam(token.NoPos, nil, "", typ))
+			}
+		}
+		if e.Results != nil {
+			for _, f := range e.Results.List {
+				results = append(results, NewParam(token.NoPos, nil, "", registryType(f.Type, params)))
+			}
+		}
+		return NewSignature(nil, NewTuple(args...), NewTuple(results...), false)
+	case *ast.StructType:
+		var fields []*Var
+		for _, f := range e.Fields.List {
+			typ := registryType(f.Type, params)
+			for _, name := range f.Names {
+				fields = append(fields, NewField(token.NoPos, nil, name.Name, typ, false))
+			}
+		}
+		return NewStruct(fields, nil)
+	}
+	panic("unsupported type in registry signature: " + ExprString(e))
 }
 
-func makePlyMethod(name string, res Type, args ...Type) (*Func, []int, bool) {
-	f := NewFunc(token.NoPos, nil, name, makeSig(res, args...))
-	var i int
-	for i = range predeclaredPlyMethods {
-		if predeclaredPlyMethods[i].name == name {
-			break
-		}
+// plyEntry returns the type of the entries of a map with key type K and
+// element type V, i.e. struct{Key K; Val V}.
+func plyEntry(K, V Type) *Struct {
+	return NewStruct([]*Var{
+		NewField(token.NoPos, nil, "Key", K, false),
+		NewField(token.NoPos, nil, "Val", V, false),
+	}, nil)
+}
+
+// isSetElem reports whether a map with element type S is a set, i.e. whether
+// S is struct{} or bool.
+func isSetElem(S Type) bool {
+	if s, ok := S.Underlying().(*Struct); ok {
+		return s.NumFields() == 0
 	}
-	return f, []int{i}, false
+	return isBoolean(S)
 }
 
-func makeSpecialPlyMethod(name string, typ Type) (*Func, []int, bool) {
-	var id int
-	for id = range predeclaredPlyMethods {
-		if predeclaredPlyMethods[id].name == name {
-			break
+// IsPlyMethod reports whether name is the name of a ply method of any
+// receiver type. It is intended for tools that operate on .ply files without
+// type information.
+func IsPlyMethod(name string) bool {
+	_, ok := plyMethodId(name)
+	return ok
+}
+
+// PlyMethodNames returns the names of the ply methods of T, including those
+// promoted from embedded fields, in sorted order.
+func PlyMethodNames(T Type) []string {
+	var names []string
+	for _, m := range predeclaredPlyMethods {
+		if m.name == "" {
+			continue
+		}
+		if obj, _, _ := lookupPlyMethod(T, m.name); obj != nil {
+			names = append(names, m.name)
 		}
 	}
-	f := NewFunc(token.NoPos, nil, predeclaredPlyMethods[id].name, &Signature{
+	sort.Strings(names)
+	return names
+}
+
+// PlyMethodRecv returns the receiver of the ply method 'name' when it is
+// called on a value of type T: either T itself, or the embedded field that
+// the method is promoted from. It returns nil if T has no such ply method.
+func PlyMethodRecv(T Type, name string) Type {
+	obj, index, _ := lookupPlyMethod(T, name)
+	if obj == nil {
+		return nil
+	}
+	for _, i := range index[:len(index)-1] {
+		T, _ = deref(T)
+		T = T.Underlying().(*Struct).fields[i].typ
+	}
+	T, _ = deref(T)
+	return T
+}
+
+func makePlyMethod(id plyId, res Type, args ...Type) *Func {
+	return NewFunc(token.NoPos, nil, predeclaredPlyMethods[id].name, makeSig(res, args...))
+}
+
+func makeSpecialPlyMethod(id plyId, typ Type) *Func {
+	return NewFunc(token.NoPos, nil, predeclaredPlyMethods[id].name, &Signature{
 		// HACK: hide the recv type in the first param. This is because
 		// check.selector will later set recv = nil. (why?)
 		params: NewTuple(NewVar(token.NoPos, nil, "", typ)),
-		ply:    plyId(id),
+		ply:    id,
 	})
-	return f, []int{id}, false
 }